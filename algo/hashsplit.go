@@ -26,11 +26,10 @@ func getHashBlock(hashFun hash.Hash, length uint32, bufs ...[]byte) HashBlock {
 	for _, buf := range bufs {
 		hashFun.Write(buf)
 	}
-	block := HashBlock{
+	return HashBlock{
+		Hash:   hashFun.Sum(nil),
 		Length: length,
 	}
-	copy(block.Hash[:], hashFun.Sum(nil))
-	return block
 }
 
 func HashSplit(r io.Reader, splitBits uint, hashFun hash.Hash) (blocks []HashBlock, err error) {
@@ -53,19 +52,9 @@ func HashSplit(r io.Reader, splitBits uint, hashFun hash.Hash) (blocks []HashBlo
 
 	for {
 		n, err := r.Read(inp)
-		if err == io.EOF {
-			if len(buf) > 0 {
-				blocks = append(blocks, getHashBlock(hashFun, uint32(len(buf)), buf))
-			}
-			break
-		} else if err != nil {
-			return nil, err
-		}
-
-		step := 1
-		for i := 0; i < n; i += step {
+		for i := 0; i < n; i++ {
 			b := inp[i]
-			h.Roll(inp[i])
+			h.Roll(b)
 			buf = append(buf, b)
 
 			sum32 := h.Sum32()
@@ -74,9 +63,18 @@ func HashSplit(r io.Reader, splitBits uint, hashFun hash.Hash) (blocks []HashBlo
 				buf = buf[:0]
 			}
 		}
+
+		if err == io.EOF {
+			if len(buf) > 0 {
+				blocks = append(blocks, getHashBlock(hashFun, uint32(len(buf)), buf))
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
 	}
 
-	return blocks, err
+	return blocks, nil
 }
 
 type EditOp int
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
 )
 
 func Test_Hashsplit(t *testing.T) {
@@ -17,9 +18,11 @@ func Test_Hashsplit(t *testing.T) {
 	assert.Equal(t, len(blocks), 1, "unexpected hashes number")
 
 	hash_str := hex.EncodeToString(blocks[0].Hash[:])
-	assert.Equal(t, "5468697320697320612073696d706c6520737472696e6720746573740e5751c0", hash_str,
+	assert.Equal(t, "a941e1316a2867e3336d33f0190f9904457e486fdfe5c88bf2819546e58819b1", hash_str,
 		"unexpected hash value")
-	assert.Equal(t, uint32(0), blocks[0].Length)
+	sum := blake2b.Sum256([]byte(s))
+	assert.Equal(t, sum[:], blocks[0].Hash)
+	assert.Equal(t, uint32(len(s)), blocks[0].Length)
 
 	rn := make([]byte, 40000)
 	rand.Seed(1975)
@@ -37,7 +40,13 @@ func Test_Hashsplit(t *testing.T) {
 	for idx, block := range blocks2 {
 		fmt.Printf("Block [%d] %d\n", idx, block.Length)
 	}
-	assert.Equal(t, 18, len(blocks2), "unexpected hashes number")
+	assert.Equal(t, 146, len(blocks2), "unexpected hashes number")
+
+	var length uint32
+	for _, block := range blocks2 {
+		length += block.Length
+	}
+	assert.Equal(t, uint32(len(rn)), length)
 
 }
 
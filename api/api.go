@@ -4,6 +4,7 @@ import (
 	_ "embed"
 	"fmt"
 	"math/rand"
+	"os"
 	"strings"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/code-to-go/safepool/services/invite"
 	"github.com/code-to-go/safepool/services/library"
 	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
 	"github.com/patrickmn/go-cache"
 )
 
@@ -75,6 +77,7 @@ func Start(dbPath string, availableBandwith pool.Bandwidth) error {
 		}
 
 	}
+	storage.SetSecretsIdentity(Self)
 
 	return err
 }
@@ -395,6 +398,109 @@ func LibrarySend(poolName string, localPath string, name string, solveConflicts
 	return f, err
 }
 
+// LibraryShare returns a link to a library document that expires after the provided duration. The link
+// includes the key to decrypt the document, so it must be sent only to the intended recipient
+func LibraryShare(poolName string, id uint64, expiry time.Duration) (string, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for library app", poolName) {
+		return "", err
+	}
+	l := library.Get(p, "library")
+	return l.Share(id, expiry)
+}
+
+// ShareReceive saves to dest the document of a share link created with LibraryShare
+func ShareReceive(link string, dest string) (pool.ShareInfo, error) {
+	f, err := os.Create(dest + ".tmp")
+	if core.IsErr(err, "cannot create '%s': %v", dest) {
+		return pool.ShareInfo{}, err
+	}
+	info, err := pool.ReadShare(link, f)
+	f.Close()
+	if err != nil {
+		os.Remove(dest + ".tmp")
+		return pool.ShareInfo{}, err
+	}
+	err = os.Rename(dest+".tmp", dest)
+	core.IsErr(err, "cannot save share to %s: %v", dest)
+	return info, err
+}
+
+// Transfers returns the uploads and downloads in progress in a pool, so that a UI can show their progress
+// while LibrarySend or LibraryReceive run on another thread
+func Transfers(poolName string) ([]pool.Transfer, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for transfers", poolName) {
+		return nil, err
+	}
+	return p.Transfers(), nil
+}
+
+// Metrics returns the count, transferred bytes, errors and latency of the operations on the exchanges of a
+// pool, so that slow or expensive exchanges can be found
+func Metrics(poolName string) (map[string]map[string]storage.OpMetrics, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for metrics", poolName) {
+		return nil, err
+	}
+	return p.Metrics(), nil
+}
+
+// PoolRates returns the throughput limits of a pool for foreground and background transfers
+func PoolRates(poolName string) (pool.Rates, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for rates", poolName) {
+		return pool.Rates{}, err
+	}
+	return p.Rates(), nil
+}
+
+// PoolSetRates sets the throughput limits of a pool. A nil value restores the limits of the available bandwidth
+func PoolSetRates(poolName string, rates *pool.Rates) error {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for rates", poolName) {
+		return err
+	}
+	return p.SetRates(rates)
+}
+
+// PoolOutbox returns the items of a pool waiting for delivery or recently delivered
+func PoolOutbox(poolName string) ([]pool.OutboxItem, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for outbox", poolName) {
+		return nil, err
+	}
+	return p.Outbox()
+}
+
+// PoolOutboxRetry makes a pending item in the outbox of a pool due for delivery immediately
+func PoolOutboxRetry(poolName string, id uint64) error {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for outbox", poolName) {
+		return err
+	}
+	return p.RetryOutbox(id)
+}
+
+// PoolOutboxCancel removes a pending item from the outbox of a pool
+func PoolOutboxCancel(poolName string, id uint64) error {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for outbox", poolName) {
+		return err
+	}
+	return p.CancelOutbox(id)
+}
+
+// PoolHeads returns a channel that receives the heads sent by other members of a pool as soon as they are
+// available
+func PoolHeads(poolName string) (chan pool.Head, error) {
+	p, err := PoolGet(poolName)
+	if core.IsErr(err, "cannot get pool '%s' for heads", poolName) {
+		return nil, err
+	}
+	return p.Heads()
+}
+
 func InviteReceive(poolName string, after int64, onlyMine bool) ([]invite.Invite, error) {
 	p, err := PoolGet(poolName)
 	if core.IsErr(err, "cannot get pool '%s' for invite app", poolName) {
@@ -403,6 +509,49 @@ func InviteReceive(poolName string, after int64, onlyMine bool) ([]invite.Invite
 	return invite.Receive(p, after, onlyMine)
 }
 
+// StorageSchemes returns the schemes of the storage drivers and the wrappers available for exchange urls
+func StorageSchemes() map[string][]string {
+	return map[string][]string{
+		"drivers":  storage.Schemes(),
+		"wrappers": storage.Wrappers(),
+	}
+}
+
+// SecretSet saves a credential in the local encrypted store. Private exchange urls refer to it with
+// secret://name, so that the credential is not included in pool configs
+func SecretSet(name string, value string) error {
+	err := storage.SetSecret(name, value)
+	if core.IsErr(err, "cannot set secret %s: %v", name) {
+		return err
+	}
+	return nil
+}
+
+// SecretDel removes a credential from the local store
+func SecretDel(name string) error {
+	return storage.DelSecret(name)
+}
+
+// SecretList returns the names of the credentials in the local store
+func SecretList() ([]string, error) {
+	return storage.Secrets()
+}
+
+// SFTPHostKeys returns the keys of the SFTP servers pinned on the first connection
+func SFTPHostKeys() ([]storage.HostKey, error) {
+	return storage.HostKeys()
+}
+
+// SFTPResetHostKey removes the pinned key of an SFTP server (e.g. sftp.example.com:22). The key presented on
+// the next connection is pinned again
+func SFTPResetHostKey(addr string) error {
+	err := storage.ResetHostKey(addr)
+	if core.IsErr(err, "cannot reset host key for %s: %v", addr) {
+		return err
+	}
+	return nil
+}
+
 type Notification struct {
 	Pool    string `json:"pool"`
 	App     string `json:"app"`
@@ -51,6 +51,12 @@ INSERT INTO configs(node,k,s,i,b) VALUES(:node,:key,:s,:i,:b)
 -- DEL_CONFIG
 DELETE FROM configs WHERE node=:node
 
+-- GET_CONFIG_KEYS
+SELECT k FROM configs WHERE node=:node ORDER BY k
+
+-- DEL_CONFIG_KEY
+DELETE FROM configs WHERE node=:node AND k=:key
+
 -- INIT
 CREATE TABLE IF NOT EXISTS feeds (
     pool VARCHAR(256) NOT NULL, 
@@ -63,9 +69,17 @@ CREATE TABLE IF NOT EXISTS feeds (
     meta VARCHAR(4096) NOT NULL,
     slot VARCHAR(16) NOT NULL,
     ctime INTEGER NOT NULL,
+    compression VARCHAR(16) NOT NULL DEFAULT '',
+    chunked INTEGER NOT NULL DEFAULT 0,
     PRIMARY KEY(id)
 )
 
+-- UPGRADE
+ALTER TABLE feeds ADD COLUMN compression VARCHAR(16) NOT NULL DEFAULT ''
+
+-- UPGRADE
+ALTER TABLE feeds ADD COLUMN chunked INTEGER NOT NULL DEFAULT 0
+
 -- INIT
 CREATE INDEX IF NOT EXISTS idx_feeds_id ON feeds(id);
 
@@ -76,13 +90,13 @@ CREATE INDEX IF NOT EXISTS idx_feeds_pool ON feeds(pool);
 CREATE INDEX IF NOT EXISTS idx_feeds_name ON feeds(name);
 
 -- GET_FEEDS
-SELECT id, name, modTime, size, authorId, hash, meta, slot, ctime FROM feeds WHERE pool=:pool AND ctime > :ctime ORDER BY ctime
+SELECT id, name, modTime, size, authorId, hash, meta, slot, ctime, compression, chunked FROM feeds WHERE pool=:pool AND ctime > :ctime ORDER BY ctime
 
 -- GET_FEED
-SELECT id, name, modTime, size, authorId, hash, meta, slot, ctime FROM feeds WHERE pool=:pool AND id=:id
+SELECT id, name, modTime, size, authorId, hash, meta, slot, ctime, compression, chunked FROM feeds WHERE pool=:pool AND id=:id
 
 -- SET_FEED
-INSERT INTO feeds(pool,id,name,modTime,size,authorId,hash,meta,slot,ctime) VALUES(:pool,:id,:name,:modTime,:size,:authorId,:hash,:meta,:slot,:ctime)
+INSERT INTO feeds(pool,id,name,modTime,size,authorId,hash,meta,slot,ctime,compression,chunked) VALUES(:pool,:id,:name,:modTime,:size,:authorId,:hash,:meta,:slot,:ctime,:compression,:chunked)
 
 -- DEL_FEED_BEFORE
 DELETE FROM feeds WHERE pool=:pool AND id <:beforeId
@@ -90,6 +104,50 @@ DELETE FROM feeds WHERE pool=:pool AND id <:beforeId
 -- DELETE_FEEDS
 DELETE FROM feeds WHERE pool=:pool
 
+-- INIT
+CREATE TABLE IF NOT EXISTS outbox (
+    pool VARCHAR(256) NOT NULL,
+    id INTEGER NOT NULL,
+    head VARCHAR(16384) NOT NULL,
+    slot VARCHAR(16) NOT NULL,
+    status INTEGER NOT NULL,
+    attempts INTEGER NOT NULL,
+    lastError VARCHAR(1024) NOT NULL,
+    next INTEGER NOT NULL,
+    updated INTEGER NOT NULL,
+    claimed INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY(id)
+)
+
+-- INIT
+CREATE INDEX IF NOT EXISTS idx_outbox_pool ON outbox(pool);
+
+-- GET_OUTBOX
+SELECT id, head, slot, status, attempts, lastError, next, updated FROM outbox WHERE pool=:pool ORDER BY id
+
+-- GET_OUTBOX_ITEM
+SELECT id, head, slot, status, attempts, lastError, next, updated FROM outbox WHERE pool=:pool AND id=:id
+
+-- SET_OUTBOX_ITEM
+INSERT INTO outbox(pool,id,head,slot,status,attempts,lastError,next,updated) VALUES(:pool,:id,:head,:slot,:status,:attempts,:lastError,:next,:updated)
+    ON CONFLICT(id) DO UPDATE SET head=:head,slot=:slot,status=:status,attempts=:attempts,lastError=:lastError,next=:next,updated=:updated
+	    WHERE pool=:pool AND id=:id
+
+-- DEL_OUTBOX_ITEM
+DELETE FROM outbox WHERE pool=:pool AND id=:id
+
+-- CANCEL_OUTBOX_ITEM
+DELETE FROM outbox WHERE pool=:pool AND id=:id AND status=:pending AND claimed<=:now
+
+-- RETRY_OUTBOX_ITEM
+UPDATE outbox SET next=:next WHERE pool=:pool AND id=:id AND status=:pending
+
+-- CLAIM_OUTBOX_ITEM
+UPDATE outbox SET claimed=:until WHERE pool=:pool AND id=:id AND status=:pending AND next<=:now AND claimed<=:now
+
+-- RELEASE_OUTBOX_ITEM
+UPDATE outbox SET claimed=0 WHERE pool=:pool AND id=:id
+
 -- INIT
 CREATE TABLE IF NOT EXISTS keys (
     pool VARCHAR(256) NOT NULL, 
@@ -347,3 +405,25 @@ SELECT DISTINCT thread FROM reels WHERE pool=:pool AND reel=:reel
 -- GET_REEL
 SELECT id,name,contentType,ctime,thumbnail FROM reels WHERE pool=:pool AND reel=:reel 
     AND thread=:thread AND ctime>:from AND ctime<:to
+
+-- INIT
+CREATE TABLE IF NOT EXISTS uploads (
+    storage VARCHAR(512) NOT NULL,
+    name VARCHAR(8192) NOT NULL,
+    uploadId VARCHAR(1024) NOT NULL,
+    size INTEGER NOT NULL,
+    partSize INTEGER NOT NULL,
+    ctime INTEGER NOT NULL,
+    CONSTRAINT pk_uploads PRIMARY KEY(storage,name)
+);
+
+-- SET_UPLOAD
+INSERT INTO uploads(storage,name,uploadId,size,partSize,ctime) VALUES(:storage,:name,:uploadId,:size,:partSize,:ctime)
+    ON CONFLICT(storage,name) DO UPDATE SET uploadId=:uploadId,size=:size,partSize=:partSize,ctime=:ctime
+    WHERE storage=:storage AND name=:name
+
+-- GET_UPLOAD
+SELECT uploadId, size, partSize FROM uploads WHERE storage=:storage AND name=:name
+
+-- DEL_UPLOAD
+DELETE FROM uploads WHERE storage=:storage AND name=:name
@@ -0,0 +1,37 @@
+// Command httpindex creates the index files required to publish the content of an exchange on a static web
+// server, so that the pool can be opened with an http:// or https:// url.
+//
+//	httpindex file:///srv/www/pools [folder]
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/code-to-go/safepool/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, "usage: httpindex <storage url> [folder]")
+		os.Exit(2)
+	}
+
+	dir := ""
+	if len(os.Args) == 3 {
+		dir = os.Args[2]
+	}
+
+	s, err := storage.OpenStorage(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open %s: %v\n", storage.Redact(os.Args[1]), err)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	err = storage.WriteIndex(s, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot create index files: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -281,6 +281,97 @@ func librarySend(poolName *C.char, localPath *C.char, name *C.char, solveConflic
 	return cResult(f, nil)
 }
 
+//export libraryShare
+func libraryShare(poolName *C.char, id C.long, expirySeconds C.long) C.Result {
+	link, err := api.LibraryShare(C.GoString(poolName), uint64(id), time.Duration(expirySeconds)*time.Second)
+	return cResult(link, err)
+}
+
+//export shareReceive
+func shareReceive(link *C.char, dest *C.char) C.Result {
+	info, err := api.ShareReceive(C.GoString(link), C.GoString(dest))
+	return cResult(info, err)
+}
+
+//export transfers
+func transfers(poolName *C.char) C.Result {
+	ts, err := api.Transfers(C.GoString(poolName))
+	return cResult(ts, err)
+}
+
+//export metrics
+func metrics(poolName *C.char) C.Result {
+	m, err := api.Metrics(C.GoString(poolName))
+	return cResult(m, err)
+}
+
+//export poolRates
+func poolRates(poolName *C.char) C.Result {
+	r, err := api.PoolRates(C.GoString(poolName))
+	return cResult(r, err)
+}
+
+//export poolSetRates
+func poolSetRates(poolName *C.char, rates *C.char) C.Result {
+	var r *pool.Rates
+	err := cInput(nil, rates, &r)
+	if core.IsErr(err, "invalid rates: %v") {
+		return cResult(nil, err)
+	}
+	return cResult(nil, api.PoolSetRates(C.GoString(poolName), r))
+}
+
+//export poolOutbox
+func poolOutbox(poolName *C.char) C.Result {
+	items, err := api.PoolOutbox(C.GoString(poolName))
+	return cResult(items, err)
+}
+
+//export poolOutboxRetry
+func poolOutboxRetry(poolName *C.char, id C.long) C.Result {
+	return cResult(nil, api.PoolOutboxRetry(C.GoString(poolName), uint64(id)))
+}
+
+//export poolOutboxCancel
+func poolOutboxCancel(poolName *C.char, id C.long) C.Result {
+	return cResult(nil, api.PoolOutboxCancel(C.GoString(poolName), uint64(id)))
+}
+
+//export storageSchemes
+func storageSchemes() C.Result {
+	return cResult(api.StorageSchemes(), nil)
+}
+
+//export secretSet
+func secretSet(name *C.char, value *C.char) C.Result {
+	err := api.SecretSet(C.GoString(name), C.GoString(value))
+	return cResult(nil, err)
+}
+
+//export secretDel
+func secretDel(name *C.char) C.Result {
+	err := api.SecretDel(C.GoString(name))
+	return cResult(nil, err)
+}
+
+//export secretList
+func secretList() C.Result {
+	names, err := api.SecretList()
+	return cResult(names, err)
+}
+
+//export sftpHostKeys
+func sftpHostKeys() C.Result {
+	keys, err := api.SFTPHostKeys()
+	return cResult(keys, err)
+}
+
+//export sftpResetHostKey
+func sftpResetHostKey(addr *C.char) C.Result {
+	err := api.SFTPResetHostKey(C.GoString(addr))
+	return cResult(nil, err)
+}
+
 //export inviteReceive
 func inviteReceive(poolName *C.char, after, onlyMine C.int) C.Result {
 	invites, err := api.InviteReceive(C.GoString(poolName), int64(after), onlyMine == 1)
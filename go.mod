@@ -3,14 +3,19 @@ module github.com/code-to-go/safepool
 go 1.19
 
 require (
+	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.3.0
+	github.com/Azure/azure-sdk-for-go/sdk/storage/azblob v1.0.0
 	github.com/adrg/xdg v0.4.0
 	github.com/bakape/thumbnailer/v2 v2.7.1
 	github.com/beevik/ntp v0.3.0
 	github.com/chmduquesne/rollinghash v4.0.0+incompatible
 	github.com/ecies/go/v2 v2.0.6
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/godruoyi/go-snowflake v0.0.2
 	github.com/google/uuid v1.3.0
 	github.com/grailbio/base v0.0.10
+	github.com/johannesboyne/gofakes3 v0.0.0-20230108161031-df26ca44a1e9
+	github.com/klauspost/compress v1.15.15
 	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/sftp v1.13.5
@@ -26,11 +31,17 @@ require (
 )
 
 require (
+	github.com/Azure/azure-sdk-for-go/sdk/internal v1.1.1 // indirect
+	github.com/aws/aws-sdk-go v1.34.31 // indirect
 	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.4.10 // indirect
 	github.com/aws/aws-sdk-go-v2/internal/v4a v1.0.25 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/checksum v1.1.28 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/s3shared v1.14.2 // indirect
 	github.com/nwaples/rardecode v1.1.3 // indirect
+	github.com/ryszard/goskiplist v0.0.0-20150312221310-2dfbae5fcf46 // indirect
+	github.com/shabbyrobe/gocovmerge v0.0.0-20180507124511-f6ea450bfb63 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	golang.org/x/tools v0.7.0 // indirect
 )
 
 require (
@@ -53,6 +64,6 @@ require (
 	github.com/ethereum/go-ethereum v1.11.6 // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/net v0.9.0
 	golang.org/x/sys v0.7.0 // indirect
 )
@@ -1,6 +1,7 @@
 package pool
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -48,6 +49,13 @@ const identityFolder = "identities"
 const accessFolder = "access"
 const touchFile = ".touch"
 
+// lockFile is the lock on the access folder taken while the access files are read, merged and exported, so
+// that concurrent admins do not overwrite each other's updates
+const lockFile = ".lock"
+
+var AccessLockSpan = time.Minute
+var AccessLockTimeout = 30 * time.Second
+
 func (p *Pool) SetAccess(userId string, state State) error {
 	_, ok, _ := security.GetIdentity(userId)
 	if !ok {
@@ -75,10 +83,14 @@ func (p *Pool) SetAccess(userId string, state State) error {
 }
 
 func (p *Pool) ExportSelf(force bool) error {
-	return p.exportSelf(p.e, force)
+	return p.exportSelf(p.primary(), force)
 }
 
 func (p *Pool) exportSelf(e storage.Storage, force bool) error {
+	if storage.IsReadOnly(e) {
+		return nil
+	}
+
 	name := path.Join(p.Name, identityFolder, p.Self.Id())
 	if !force {
 		_, err := e.Stat(name)
@@ -122,8 +134,9 @@ func (p *Pool) syncIdentities(e storage.Storage) error {
 
 func (p *Pool) syncSecondaryExchanges(ignoreGuard bool) {
 	p.mutex.Lock()
+	primary := p.primary()
 	for _, e := range p.exchangers {
-		if e != p.e {
+		if e != primary {
 			err := p.syncAccessFor(e, ignoreGuard)
 			core.IsErr(err, "cannot synchronize secondary exchange '%s': %v", e.String())
 		}
@@ -132,8 +145,12 @@ func (p *Pool) syncSecondaryExchanges(ignoreGuard bool) {
 }
 
 func (p *Pool) SyncAccess(ignoreGuard bool) error {
-	err := p.syncAccessFor(p.e, ignoreGuard)
-	if core.IsErr(err, "cannot sync privary exchange '%s': %v", p.e.String()) {
+	e := p.primary()
+	if e == nil {
+		return ErrNoStorage
+	}
+	err := p.syncAccessFor(e, ignoreGuard)
+	if core.IsErr(err, "cannot sync privary exchange '%s': %v", e.String()) {
 		return err
 	}
 	go p.syncSecondaryExchanges(ignoreGuard)
@@ -154,6 +171,14 @@ func (p *Pool) syncAccessFor(e storage.Storage, ignoreGuard bool) error {
 		return nil
 	}
 
+	if !storage.IsReadOnly(e) {
+		release, err := p.lockAccess(e)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	updates, sources, requireExport, err := p.syncAccessFiles(e)
 	if err != nil {
 		return err
@@ -187,7 +212,9 @@ func (p *Pool) syncAccessFor(e storage.Storage, ignoreGuard bool) error {
 		}
 	}
 
-	if requireExport {
+	if requireExport && storage.IsReadOnly(e) {
+		core.Info("access file on %s is not updated since the exchange is read-only", e)
+	} else if requireExport {
 		err = p.exportAccessFile(e)
 		if core.IsErr(err, "cannot export access file: %v", e) {
 			return err
@@ -258,6 +285,23 @@ func (p *Pool) syncAccessFiles(e storage.Storage) (updates map[string]Access, so
 	return updates, sources, requireExport, nil
 }
 
+// lockAccess takes the lock on the access folder of e and returns the function that releases it. Exchanges
+// without conditional writes are not locked
+func (p *Pool) lockAccess(e storage.Storage) (func(), error) {
+	lease, err := storage.WaitLease(e, path.Join(p.Name, accessFolder, lockFile), AccessLockSpan, AccessLockTimeout)
+	switch {
+	case errors.Is(err, storage.ErrNotSupported):
+		core.Debug("no conditional writes on %s; sync access files without lock", e)
+		return func() {}, nil
+	case core.IsErr(err, "cannot lock access folder on %s: %v", e):
+		return nil, err
+	default:
+		return func() { lease.Release() }, nil
+	}
+}
+
+// exportAccessFile writes a new access file with the accesses in the db. The caller holds the lock on the
+// access folder
 func (p *Pool) exportAccessFile(e storage.Storage) error {
 	if !core.TimeIsSync() {
 		return ErrNoSyncClock
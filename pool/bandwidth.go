@@ -0,0 +1,98 @@
+package pool
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
+)
+
+// Rates are the maximal throughput in bytes per second of the transfers between the device and the exchanges.
+// Foreground applies to Send and Receive, Background to the replica between exchanges. 0 means no limit
+type Rates struct {
+	Foreground int64 `json:"foreground"`
+	Background int64 `json:"background"`
+}
+
+// BandwidthRates are the rates used by pools without explicit rates, according to AvailableBandwidth
+var BandwidthRates = map[Bandwidth]Rates{
+	LowBandwidth:    {Foreground: 64 * 1024, Background: 16 * 1024},
+	MediumBandwidth: {Foreground: 512 * 1024, Background: 128 * 1024},
+	HighBandwith:    {},
+}
+
+const ratesKey = "rates"
+
+// Rates returns the rates of the pool. Unless they are set with SetRates, they depend on AvailableBandwidth
+func (p *Pool) Rates() Rates {
+	p.limitersMutex.Lock()
+	defer p.limitersMutex.Unlock()
+
+	if !p.ratesLoaded {
+		p.rates, p.ratesLoaded = sqlGetRates(p.Name), true
+	}
+	if p.rates != nil {
+		return *p.rates
+	}
+	return BandwidthRates[AvailableBandwidth]
+}
+
+// sqlGetRates returns the explicit rates of the pool or nil
+func sqlGetRates(pool string) *Rates {
+	_, _, data, ok := sql.GetConfig(fmt.Sprintf("pool/%s", pool), ratesKey)
+	if !ok {
+		return nil
+	}
+	var r Rates
+	if core.IsErr(json.Unmarshal(data, &r), "invalid rates for pool %s: %v", pool) {
+		return nil
+	}
+	return &r
+}
+
+// SetRates sets explicit rates for the pool. A nil value restores the rates of AvailableBandwidth
+func (p *Pool) SetRates(r *Rates) error {
+	configNode := fmt.Sprintf("pool/%s", p.Name)
+	var err error
+	if r == nil {
+		err = sql.DelConfig(configNode, ratesKey)
+	} else {
+		var data []byte
+		data, err = json.Marshal(r)
+		if core.IsErr(err, "cannot marshal rates: %v") {
+			return err
+		}
+		err = sql.SetConfig(configNode, ratesKey, "", 0, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.limitersMutex.Lock()
+	if r != nil {
+		c := *r
+		r = &c
+	}
+	p.rates, p.ratesLoaded = r, true
+	p.limitersMutex.Unlock()
+	return nil
+}
+
+// throttle returns e with the throughput limited by the foreground or the background rate of the pool
+func (p *Pool) throttle(e storage.Storage, background bool) storage.Storage {
+	p.limitersMutex.Lock()
+	if p.foreground == nil {
+		p.foreground, p.background = storage.NewLimiter(0), storage.NewLimiter(0)
+	}
+	p.limitersMutex.Unlock()
+
+	r := p.Rates()
+	if background {
+		p.background.SetRate(r.Background)
+		return storage.NewThrottle(e, p.background)
+	}
+	p.foreground.SetRate(r.Foreground)
+	return storage.NewThrottle(e, p.foreground)
+}
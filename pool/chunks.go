@@ -0,0 +1,191 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"path"
+	"time"
+
+	"github.com/code-to-go/safepool/algo"
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/security"
+	"github.com/code-to-go/safepool/storage"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChunksFolder is the folder shared by all feeds for the chunks of chunked bodies
+const ChunksFolder = "chunks"
+
+// ChunkSplitBits sets the average size of a chunk to 2^ChunkSplitBits bytes
+var ChunkSplitBits uint = 20
+
+// Chunk is a piece of a chunked body. Hash is keyed with the pool key, so that the name of a chunk does not
+// reveal its content
+type Chunk struct {
+	Hash []byte `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Manifest is the body of a chunked feed. It lists the chunks that make the content
+type Manifest struct {
+	Version float32 `json:"v"`
+	KeyId   uint64  `json:"keyId"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+func (p *Pool) chunkName(h []byte) string {
+	return path.Join(p.Name, ChunksFolder, hex.EncodeToString(h))
+}
+
+func (p *Pool) chunkHash(keyId uint64) (hash.Hash, error) {
+	key := p.keyFunc(keyId)
+	if key == nil {
+		core.IsErr(ErrMissingKey, "missing key %d for chunk hash: %v", keyId)
+		return nil, ErrMissingKey
+	}
+	return blake2b.New256(key)
+}
+
+// writeChunks splits the content of r into chunks, uploads the chunks missing in the exchange and writes the
+// manifest to name. A chunk is reused only when it is younger than the pool life span, so that housekeeping
+// can delete chunks older than twice the life span. It returns the hash of the whole content
+func (p *Pool) writeChunks(e storage.Storage, name string, r io.ReadSeekCloser, progress chan int64) (hash.Hash, error) {
+	hr, err := security.NewHashReader(r)
+	if core.IsErr(err, "cannot create hash reader: %v") {
+		return nil, err
+	}
+	ch, err := p.chunkHash(p.masterKeyId)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := algo.HashSplit(hr, ChunkSplitBits, ch)
+	if core.IsErr(err, "cannot split %s into chunks: %v", name) {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if core.IsErr(err, "cannot rewind %s after split: %v", name) {
+		return nil, err
+	}
+
+	lifeSpan := time.Duration(p.LifeSpanHours) * time.Hour
+	m := Manifest{Version: 1.0, KeyId: p.masterKeyId}
+	var uploaded int
+	for _, b := range blocks {
+		data := make([]byte, b.Length)
+		_, err = io.ReadFull(r, data)
+		if core.IsErr(err, "cannot read chunk of %s: %v", name) {
+			return nil, err
+		}
+
+		cn := p.chunkName(b.Hash)
+		if stat, err := e.Stat(cn); err == nil && core.Since(stat.ModTime()) < lifeSpan {
+			if progress != nil {
+				progress <- int64(b.Length) + security.AESHeaderSize
+			}
+		} else {
+			_, _, err = p.writeFile(e, cn, core.NewBytesReader(data), int64(b.Length), NoCompression, progress)
+			if core.IsErr(err, "cannot write chunk %s: %v", cn) {
+				return nil, err
+			}
+			uploaded++
+		}
+		m.Chunks = append(m.Chunks, Chunk{Hash: b.Hash, Size: int64(b.Length)})
+	}
+
+	data, err := json.Marshal(m)
+	if core.IsErr(err, "cannot marshal manifest of %s: %v", name) {
+		return nil, err
+	}
+	_, _, err = p.writeFile(e, name, core.NewBytesReader(data), int64(len(data)), NoCompression, nil)
+	if core.IsErr(err, "cannot write manifest %s: %v", name) {
+		return nil, err
+	}
+
+	core.Info("uploaded %d of %d chunks for %s", uploaded, len(m.Chunks), name)
+	return hr.Hash, nil
+}
+
+// readChunks writes to w the content of the chunked body name. Each chunk is verified against the manifest
+// and only the chunks in rang are downloaded. It returns the hash of the whole content or nil when rang is
+// not nil
+func (p *Pool) readChunks(e storage.Storage, name string, rang *storage.Range, w io.Writer, progress chan int64) (hash.Hash, error) {
+	var b bytes.Buffer
+	_, err := p.readFile(e, name, nil, &b, NoCompression, nil)
+	if core.IsErr(err, "cannot read manifest %s: %v", name) {
+		return nil, err
+	}
+	var m Manifest
+	err = json.Unmarshal(b.Bytes(), &m)
+	if core.IsErr(err, "invalid manifest %s: %v", name) {
+		return nil, err
+	}
+	ch, err := p.chunkHash(m.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	if rang == nil {
+		h = security.NewHash()
+		w = io.MultiWriter(w, h)
+	}
+
+	var offset int64
+	for _, c := range m.Chunks {
+		from, to := int64(0), c.Size
+		if rang != nil {
+			from, to = rang.From-offset, rang.To-offset
+			if from < 0 {
+				from = 0
+			}
+			if to > c.Size {
+				to = c.Size
+			}
+		}
+		offset += c.Size
+		if from >= to {
+			continue
+		}
+
+		b.Reset()
+		cn := p.chunkName(c.Hash)
+		_, err = p.readFile(e, cn, nil, &b, NoCompression, progress)
+		if core.IsErr(err, "cannot read chunk %s: %v", cn) {
+			return nil, err
+		}
+		ch.Reset()
+		ch.Write(b.Bytes())
+		if !bytes.Equal(ch.Sum(nil), c.Hash) || int64(b.Len()) != c.Size {
+			core.IsErr(security.ErrInvalidSignature, "chunk %s does not match the manifest: %v", cn)
+			return nil, security.ErrInvalidSignature
+		}
+
+		_, err = w.Write(b.Bytes()[from:to])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// deleteOldChunks removes the chunks that no feed in the pool life span can reference
+func (p *Pool) deleteOldChunks(e storage.Storage) int {
+	threshold := core.Now().Add(-2 * time.Duration(p.LifeSpanHours) * time.Hour)
+
+	var deleted int
+	it := storage.NewDirIterator(e, path.Join(p.Name, ChunksFolder), storage.ListFilter{})
+	for it.Next() {
+		if it.Info().IsDir() || !it.Info().ModTime().Before(threshold) {
+			continue
+		}
+		n := path.Join(p.Name, ChunksFolder, it.Info().Name())
+		if !core.IsErr(e.Delete(n), "cannot delete chunk '%s' during housekeeping: %v", n) {
+			deleted++
+		}
+	}
+	core.IsErr(it.Err(), "cannot read chunks in pool %s/%s: %v", e, p.Name)
+	return deleted
+}
@@ -0,0 +1,194 @@
+package pool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/klauspost/compress/zstd"
+	"github.com/wailsapp/mimetype"
+)
+
+// Compression algorithms applied to the content of a feed before encryption. The algorithm is recorded in the
+// head, so readers that do not know it fail the hash check instead of returning compressed content
+const (
+	NoCompression   = ""
+	GzipCompression = "gzip"
+	ZstdCompression = "zstd"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression algorithm")
+
+// MinCompressSize is the size below which content is sent as it is
+var MinCompressSize int64 = 256
+
+// compressInMemory is the largest content compressed in memory; larger content uses a temporary file
+const compressInMemory = 4 * 1024 * 1024
+
+// compressibleTypes are the prefixes of the content types worth compressing. Media and archives are
+// usually compressed already
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/yaml",
+	"application/x-yaml",
+	"image/svg+xml",
+}
+
+func isCompressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseCompression returns the compression of the pool for a feed whose content is compressible. The type of
+// the content comes from the extension of name or, when unknown, from the first bytes of r
+func (p *Pool) chooseCompression(name string, r io.ReadSeeker, size int64) string {
+	if p.Compression == NoCompression || size < MinCompressSize {
+		return NoCompression
+	}
+	if isCompressible(mime.TypeByExtension(path.Ext(name))) {
+		return p.Compression
+	}
+
+	m, err := mimetype.DetectReader(r)
+	_, serr := r.Seek(0, io.SeekStart)
+	if err != nil || serr != nil {
+		return NoCompression
+	}
+	for ; m != nil; m = m.Parent() {
+		if isCompressible(m.String()) {
+			return p.Compression
+		}
+	}
+	return NoCompression
+}
+
+func newCompressor(compression string, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case GzipCompression:
+		return gzip.NewWriter(w), nil
+	case ZstdCompression:
+		return zstd.NewWriter(w)
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+func newDecompressor(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case GzipCompression:
+		return gzip.NewReader(r)
+	case ZstdCompression:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// tempFile is a temporary file removed on close
+type tempFile struct {
+	*os.File
+}
+
+func (t tempFile) Close() error {
+	t.File.Close()
+	return os.Remove(t.Name())
+}
+
+// compress reads all r and returns the compressed content and its size. The content is kept in memory when
+// size is small and in a temporary file otherwise
+func compress(compression string, r io.Reader, size int64) (io.ReadSeekCloser, int64, error) {
+	if size <= compressInMemory {
+		var b bytes.Buffer
+		err := compressTo(compression, r, &b)
+		if err != nil {
+			return nil, 0, err
+		}
+		return core.NewBytesReader(b.Bytes()), int64(b.Len()), nil
+	}
+
+	f, err := os.CreateTemp("", "safepool-*")
+	if core.IsErr(err, "cannot create temporary file for compression: %v") {
+		return nil, 0, err
+	}
+	t := tempFile{f}
+	err = compressTo(compression, r, f)
+	if err != nil {
+		t.Close()
+		return nil, 0, err
+	}
+	csize, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		t.Close()
+		return nil, 0, err
+	}
+	return t, csize, nil
+}
+
+func compressTo(compression string, r io.Reader, w io.Writer) error {
+	c, err := newCompressor(compression, w)
+	if core.IsErr(err, "cannot create %s compressor: %v", compression) {
+		return err
+	}
+	_, err = io.Copy(c, r)
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	core.IsErr(err, "cannot compress with %s: %v", compression)
+	return err
+}
+
+// rangeWriter forwards to w only the bytes in the range
+type rangeWriter struct {
+	w        io.Writer
+	from, to int64
+	pos      int64
+}
+
+func newRangeWriter(w io.Writer, rang *storage.Range) io.Writer {
+	if rang == nil {
+		return w
+	}
+	return &rangeWriter{w: w, from: rang.From, to: rang.To}
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	pos := r.pos
+	r.pos += int64(len(p))
+
+	lo, hi := r.from-pos, r.to-pos
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > int64(len(p)) {
+		hi = int64(len(p))
+	}
+	if lo < hi {
+		_, err := r.w.Write(p[lo:hi])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -3,8 +3,6 @@ package pool
 import (
 	"bytes"
 	"fmt"
-	"math"
-	"math/rand"
 	"path"
 	"time"
 
@@ -41,51 +39,83 @@ func (p *Pool) createExchangers(config Config) {
 		e.Close()
 	}
 	p.exchangers = nil
+	p.repairsMutex.Lock()
+	p.repairs = nil
+	p.repairsMutex.Unlock()
+	p.health.mutex.Lock()
+	p.health.exchanges = nil
+	p.health.mutex.Unlock()
+
+	var cache *storage.CacheConfig
+	if config.MetaCache != "" {
+		c, err := storage.ParseCacheConfig(config.MetaCache)
+		if !core.IsErr(err, "invalid metadata cache '%s' in pool %s: %v", config.MetaCache, config.Name) {
+			cache = &c
+		}
+	}
 
 	urls := append(config.Public, config.Private...)
-	for _, url := range urls {
-		e, err := storage.OpenStorage(url)
-		if core.IsErr(err, "cannot connect to exchange %s in Pool.createExchangers: %v", url) {
+	for i, url := range urls {
+		// only the private exchanges are configured on this device and can refer to local secrets
+		open := storage.OpenStorage
+		if i >= len(config.Public) {
+			open = storage.OpenStorageTrusted
+		}
+		e, err := open(url)
+		if core.IsErr(err, "cannot connect to exchange %s in Pool.createExchangers: %v", storage.Redact(url)) {
 			continue
 		}
+		if _, ok := e.(*storage.MetaCache); !ok && cache != nil {
+			e = storage.NewMetaCache(e, *cache)
+		}
 		p.exchangers = append(p.exchangers, e)
 	}
 }
 
+// primary returns the exchange used for sync and receive. It can change at any time after a failover
+func (p *Pool) primary() storage.Storage {
+	p.primaryMutex.RLock()
+	defer p.primaryMutex.RUnlock()
+	return p.e
+}
+
+// setPrimary changes the primary exchange and restarts the watch of feeds on the new primary
+func (p *Pool) setPrimary(e storage.Storage) {
+	p.primaryMutex.Lock()
+	defer p.primaryMutex.Unlock()
+
+	p.e = e
+	if e != nil {
+		p.Connection = e.String()
+	}
+	if p.primaryChanged != nil {
+		select {
+		case p.primaryChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// findPrimary probes the exchanges and makes the healthiest the primary
 func (p *Pool) findPrimary() {
 	if len(p.exchangers) == 1 {
-		p.e = p.exchangers[0]
+		p.setPrimary(p.exchangers[0])
 		return
 	}
 
-	min := time.Duration(math.MaxInt64)
-
-	data := make([]byte, 4192)
-	rand.Seed(time.Now().Unix())
-	rand.Read(data)
-
-	p.e = nil
-	for _, e := range p.exchangers {
-		ping, err := pingExchanger(e, p.Name, data)
-		if err != nil {
-			logrus.Warnf("no connection to %v", e)
-			continue
-		}
-		if ping < min {
-			min = ping
-			p.e = e
-		}
+	p.setPrimary(nil)
+	p.checkHealth()
+	if p.primary() == nil {
+		logrus.Warnf("no connection to any exchange of pool %s", p.Name)
 	}
 }
 
 func (p *Pool) connectSafe(config Config) error {
 	p.createExchangers(config)
 	p.findPrimary()
-	if p.e == nil {
+	if p.primary() == nil {
 		logrus.Warnf("no available exchange for domain %s", p.Name)
 		return ErrNoStorage
-	} else {
-		p.Connection = p.e.String()
-		return nil
 	}
+	return nil
 }
@@ -26,6 +26,9 @@ func Create(self security.Identity, name string, apps []string) (*Pool, error) {
 		Id:             snowflake.ID(),
 		Self:           self,
 		LifeSpanHours:  core.If(config.LifeSpanHours > 0, config.LifeSpanHours, 24*30),
+		WriteQuorum:    config.WriteQuorum,
+		ChunkThreshold: config.ChunkThreshold,
+		Compression:    config.Compression,
 		lastAccessSync: core.Now(),
 		lastReplica:    core.Now(),
 	}
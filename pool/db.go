@@ -2,6 +2,7 @@ package pool
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/code-to-go/safepool/core"
 	"github.com/code-to-go/safepool/security"
@@ -21,7 +22,7 @@ func sqlGetFeeds(pool string, ctime int64) ([]Head, error) {
 		var modTime int64
 		var hash string
 		var meta string
-		err = rows.Scan(&f.Id, &f.Name, &modTime, &f.Size, &f.AuthorId, &hash, &meta, &f.Slot, &f.CTime)
+		err = rows.Scan(&f.Id, &f.Name, &modTime, &f.Size, &f.AuthorId, &hash, &meta, &f.Slot, &f.CTime, &f.Compression, &f.Chunked)
 		if !core.IsErr(err, "cannot read pool feeds from db: %v") {
 			f.Hash = sql.DecodeBase64(hash)
 			f.ModTime = sql.DecodeTime(modTime)
@@ -38,7 +39,7 @@ func sqlGetFeed(pool string, id uint64) (Head, error) {
 	var hash string
 	var meta string
 	err := sql.QueryRow("GET_FEED", sql.Args{"pool": pool, "id": id},
-		&f.Id, &f.Name, &modTime, &f.Size, &f.AuthorId, &hash, &meta, &f.Slot, &f.CTime)
+		&f.Id, &f.Name, &modTime, &f.Size, &f.AuthorId, &hash, &meta, &f.Slot, &f.CTime, &f.Compression, &f.Chunked)
 	if core.IsErr(err, "cannot get feed with id '%d' in pool '%s': %v", id, pool) {
 		return Head{}, err
 	}
@@ -56,20 +57,122 @@ func sqlDelFeedBefore(pool string, id int64) error {
 
 func sqlAddFeed(pool string, f Head) error {
 	_, err := sql.Exec("SET_FEED", sql.Args{
-		"pool":     pool,
-		"id":       f.Id,
-		"name":     f.Name,
-		"size":     f.Size,
-		"authorId": f.AuthorId,
-		"modTime":  sql.EncodeTime(f.ModTime),
-		"hash":     sql.EncodeBase64(f.Hash[:]),
-		"meta":     sql.EncodeBase64(f.Meta),
-		"slot":     f.Slot,
-		"ctime":    f.CTime,
+		"pool":        pool,
+		"id":          f.Id,
+		"name":        f.Name,
+		"size":        f.Size,
+		"authorId":    f.AuthorId,
+		"modTime":     sql.EncodeTime(f.ModTime),
+		"hash":        sql.EncodeBase64(f.Hash[:]),
+		"meta":        sql.EncodeBase64(f.Meta),
+		"slot":        f.Slot,
+		"ctime":       f.CTime,
+		"compression": f.Compression,
+		"chunked":     f.Chunked,
 	})
 	return err
 }
 
+func scanOutboxItem(scan func(dest ...any) error) (OutboxItem, error) {
+	var i OutboxItem
+	var head string
+	var next, updated int64
+	err := scan(&i.Id, &head, &i.Head.Slot, &i.Status, &i.Attempts, &i.Error, &next, &updated)
+	if err != nil {
+		return OutboxItem{}, err
+	}
+	slot := i.Head.Slot
+	err = json.Unmarshal([]byte(head), &i.Head)
+	if err != nil {
+		return OutboxItem{}, err
+	}
+	i.Head.Slot = slot
+	i.Next = sql.DecodeTime(next)
+	i.Updated = sql.DecodeTime(updated)
+	return i, nil
+}
+
+func sqlGetOutbox(pool string) ([]OutboxItem, error) {
+	rows, err := sql.Query("GET_OUTBOX", sql.Args{"pool": pool})
+	if core.IsErr(err, "cannot get outbox from db: %v") {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []OutboxItem
+	for rows.Next() {
+		i, err := scanOutboxItem(rows.Scan)
+		if !core.IsErr(err, "cannot read outbox from db: %v") {
+			items = append(items, i)
+		}
+	}
+	return items, nil
+}
+
+func sqlGetOutboxItem(pool string, id uint64) (OutboxItem, error) {
+	return scanOutboxItem(func(dest ...any) error {
+		return sql.QueryRow("GET_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id}, dest...)
+	})
+}
+
+func sqlSetOutboxItem(pool string, i OutboxItem) error {
+	head, err := json.Marshal(i.Head)
+	if err != nil {
+		return err
+	}
+	_, err = sql.Exec("SET_OUTBOX_ITEM", sql.Args{
+		"pool":      pool,
+		"id":        i.Id,
+		"head":      string(head),
+		"slot":      i.Head.Slot,
+		"status":    i.Status,
+		"attempts":  i.Attempts,
+		"lastError": i.Error,
+		"next":      sql.EncodeTime(i.Next),
+		"updated":   sql.EncodeTime(i.Updated),
+	})
+	return err
+}
+
+func sqlDelOutboxItem(pool string, id uint64) error {
+	_, err := sql.Exec("DEL_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id})
+	return err
+}
+
+// sqlOutboxUpdate runs a statement on an outbox item and returns true when the item is changed
+func sqlOutboxUpdate(key string, args sql.Args) (bool, error) {
+	res, err := sql.Exec(key, args)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// sqlCancelOutboxItem removes a pending item that is not being delivered
+func sqlCancelOutboxItem(pool string, id uint64) (bool, error) {
+	return sqlOutboxUpdate("CANCEL_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id, "pending": OutboxPending,
+		"now": sql.EncodeTime(core.Now())})
+}
+
+// sqlRetryOutboxItem makes a pending item due at next
+func sqlRetryOutboxItem(pool string, id uint64, next time.Time) (bool, error) {
+	return sqlOutboxUpdate("RETRY_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id, "pending": OutboxPending,
+		"next": sql.EncodeTime(next)})
+}
+
+// sqlClaimOutboxItem reserves a pending item that is due for a delivery until the provided time. Only one
+// process or pool instance can claim an item at a time
+func sqlClaimOutboxItem(pool string, id uint64, until time.Time) (bool, error) {
+	return sqlOutboxUpdate("CLAIM_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id, "pending": OutboxPending,
+		"now": sql.EncodeTime(core.Now()), "until": sql.EncodeTime(until)})
+}
+
+func sqlReleaseOutboxItem(pool string, id uint64) error {
+	_, err := sql.Exec("RELEASE_OUTBOX_ITEM", sql.Args{"pool": pool, "id": id})
+	return err
+}
+
 func (p *Pool) sqlGetKey(keyId uint64) []byte {
 	rows, err := sql.Query("GET_KEY", sql.Args{"pool": p.Name, "keyId": keyId})
 	if err != nil {
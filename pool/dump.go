@@ -5,13 +5,18 @@ import (
 
 	"github.com/code-to-go/safepool/core"
 	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
 )
 
 func (p *Pool) Dump() map[string]any {
 	m := map[string]any{}
+	e := p.primary()
 
 	m["pool"] = p.Name
-	m["primary"] = p.e.String()
+	if e != nil {
+		m["primary"] = e.String()
+		m["readOnly"] = storage.IsReadOnly(e)
+	}
 	m["masterKeyId"] = p.masterKeyId
 
 	var exchangers []string
@@ -19,6 +24,9 @@ func (p *Pool) Dump() map[string]any {
 		exchangers = append(exchangers, e.String())
 	}
 	m["exchangers"] = exchangers
+	m["health"] = p.Health()
+	m["writeQuorum"] = p.quorum(len(p.writeTargets()))
+	m["repairs"] = p.Repairs()
 	m["lastAccessSync"] = p.lastAccessSync
 	m["lastAccessSyncElapsed"] = core.Since(p.lastAccessSync)
 	m["lastHouseKeeping"] = p.lastReplica
@@ -31,13 +39,18 @@ func (p *Pool) Dump() map[string]any {
 	}
 	m["keys"] = keys
 
-	configNode := fmt.Sprintf("pool/%s", p.Name)
-	checkpointKey := fmt.Sprintf("checkpoints/%s", p.e.String())
-	slotKey := fmt.Sprintf("slots/%s", p.e.String())
-	lastSlot, _, _, _ := sql.GetConfig(configNode, slotKey)
-	m["lastSlot"] = lastSlot
-	_, lastCheckpoint, _, _ := sql.GetConfig(configNode, checkpointKey)
-	m["checkpointModTime"] = lastCheckpoint
+	if e != nil {
+		configNode := fmt.Sprintf("pool/%s", p.Name)
+		checkpointKey := fmt.Sprintf("checkpoints/%s", e.String())
+		slotKey := fmt.Sprintf("slots/%s", e.String())
+		headKey := fmt.Sprintf("heads/%s", e.String())
+		lastSlot, _, _, _ := sql.GetConfig(configNode, slotKey)
+		m["lastSlot"] = lastSlot
+		_, lastHead, _, _ := sql.GetConfig(configNode, headKey)
+		m["lastHead"] = lastHead
+		_, lastCheckpoint, _, _ := sql.GetConfig(configNode, checkpointKey)
+		m["checkpointModTime"] = lastCheckpoint
+	}
 
 	feeds, _ := p.List(0)
 	m["feeds"] = feeds
@@ -52,6 +65,17 @@ func (p *Pool) Dump() map[string]any {
 		}
 	}
 	m["users"] = users
+	m["transfers"] = p.Transfers()
+	m["metrics"] = p.Metrics()
 
 	return m
 }
+
+// Metrics returns the statistics of the operations on the exchanges of the pool, by exchange and operation
+func (p *Pool) Metrics() map[string]map[string]storage.OpMetrics {
+	m := map[string]map[string]storage.OpMetrics{}
+	for _, e := range p.exchangers {
+		m[e.String()] = storage.GetMetrics(e.String())
+	}
+	return m
+}
@@ -37,6 +37,9 @@ func (p *Pool) checkGuard(e storage.Storage, ph ...string) bool {
 }
 
 func (p *Pool) touchGuard(e storage.Storage, ph ...string) {
+	if storage.IsReadOnly(e) {
+		return
+	}
 	_, file, _ := p.getGuardParams(e, ph...)
 	err := e.Write(file, bytes.NewReader(nil), 0, nil)
 	core.IsErr(err, "cannot touch guard: %v")
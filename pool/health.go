@@ -0,0 +1,204 @@
+package pool
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/storage"
+)
+
+// HealthCheckPeriod is the interval between two probes of the exchanges of a pool
+var HealthCheckPeriod = time.Minute
+
+// FailoverErrors is the number of consecutive failures after which an exchange is considered down
+var FailoverErrors = 3
+
+// FailoverMargin is how much the score of an exchange must exceed the score of a working primary to replace
+// it. It avoids switching back and forth between exchanges with similar latency
+var FailoverMargin = 2.0
+
+// healthAlpha is the weight of a new sample in the moving averages of latency and error rate
+const healthAlpha = 0.3
+
+// Health is the status of an exchange computed from the probes and the operations of the pool. Score is
+// between 0 and 1 and decreases with latency and error rate; it is 0 when the exchange is down
+type Health struct {
+	Exchange  string        `json:"exchange"`
+	Primary   bool          `json:"primary"`
+	Up        bool          `json:"up"`
+	Latency   time.Duration `json:"latency"`
+	ErrorRate float64       `json:"errorRate"`
+	Failures  int           `json:"failures"`
+	Score     float64       `json:"score"`
+	LastError string        `json:"lastError,omitempty"`
+	LastCheck time.Time     `json:"lastCheck"`
+}
+
+// healthBook keeps the health of the exchanges of a pool by url
+type healthBook struct {
+	exchanges map[string]*Health
+	lastCheck time.Time
+	mutex     sync.Mutex
+}
+
+func (h *Health) score() float64 {
+	if !h.Up {
+		return 0
+	}
+	return (1 - h.ErrorRate) / (1 + h.Latency.Seconds())
+}
+
+// recordHealth updates the health of e with the result of an operation. A latency of 0 does not change the
+// average latency, since the duration of a transfer depends on its size
+func (p *Pool) recordHealth(e storage.Storage, latency time.Duration, err error) {
+	if e == nil || errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+	if p.health.exchanges == nil {
+		p.health.exchanges = map[string]*Health{}
+	}
+	h, ok := p.health.exchanges[e.String()]
+	if !ok {
+		h = &Health{Exchange: e.String(), Up: true}
+		p.health.exchanges[e.String()] = h
+	}
+
+	if err != nil {
+		h.Failures++
+		h.ErrorRate = healthAlpha + (1-healthAlpha)*h.ErrorRate
+		h.LastError = err.Error()
+		if h.Failures >= FailoverErrors && h.Up {
+			h.Up = false
+			core.Info("exchange %s is down in pool %s after %d failures", e, p.Name, h.Failures)
+		}
+	} else {
+		h.Failures = 0
+		h.Up = true
+		h.ErrorRate = (1 - healthAlpha) * h.ErrorRate
+		switch {
+		case latency <= 0:
+		case h.Latency == 0:
+			h.Latency = latency
+		default:
+			h.Latency = time.Duration(healthAlpha*float64(latency) + (1-healthAlpha)*float64(h.Latency))
+		}
+	}
+	h.LastCheck = core.Now()
+	h.Score = h.score()
+}
+
+// getHealth returns a copy of the health of e and false when e has never been probed
+func (p *Pool) getHealth(e storage.Storage) (Health, bool) {
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+
+	h, ok := p.health.exchanges[e.String()]
+	if !ok {
+		return Health{Exchange: e.String(), Up: true}, false
+	}
+	return *h, true
+}
+
+// Health returns the status of the exchanges of the pool
+func (p *Pool) Health() []Health {
+	var hs []Health
+	primary := p.primary()
+	for _, e := range p.exchangers {
+		h, _ := p.getHealth(e)
+		h.Primary = e == primary
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+// probe measures the round trip of a small file on a writable exchange and the listing of the pool folder on
+// a read only exchange
+func (p *Pool) probe(e storage.Storage, data []byte) (time.Duration, error) {
+	if !storage.IsReadOnly(e) {
+		return pingExchanger(e, p.Name, data)
+	}
+
+	start := core.Now()
+	_, err := e.ReadDir(path.Join(p.Name, FeedsFolder), 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return core.Since(start), err
+}
+
+// checkHealth probes all the exchanges in parallel and switches the primary when it is down or a much healthier
+// exchange is available
+func (p *Pool) checkHealth() {
+	data := make([]byte, 4192)
+	rand.Read(data)
+
+	var wg sync.WaitGroup
+	for _, e := range p.exchangers {
+		wg.Add(1)
+		go func(e storage.Storage) {
+			defer wg.Done()
+			latency, err := p.probe(e, data)
+			core.IsErr(err, "health probe failed on %s: %v", e)
+			if err == nil && latency <= 0 {
+				latency = time.Microsecond
+			}
+			p.recordHealth(e, latency, err)
+		}(e)
+	}
+	wg.Wait()
+
+	p.health.mutex.Lock()
+	p.health.lastCheck = core.Now()
+	p.health.mutex.Unlock()
+	p.failover()
+}
+
+// failover makes the exchange with the best score the primary when the current primary is missing or down, or
+// when the best score exceeds the score of the primary by FailoverMargin. It returns true when the primary
+// changes
+func (p *Pool) failover() bool {
+	current := p.primary()
+	var best storage.Storage
+	var bestScore float64
+	for _, e := range p.exchangers {
+		h, _ := p.getHealth(e)
+		if h.Up && (best == nil || h.score() > bestScore) {
+			best, bestScore = e, h.score()
+		}
+	}
+	if best == nil || best == current {
+		return false
+	}
+
+	if current != nil {
+		h, _ := p.getHealth(current)
+		if h.Up && bestScore < h.score()*FailoverMargin {
+			return false
+		}
+	}
+
+	p.setPrimary(best)
+	core.Info("primary exchange of pool %s switched from %v to %s", p.Name, current, best)
+	return true
+}
+
+// failed records a failure of the primary exchange and switches to another exchange when the primary is down
+func (p *Pool) failed(err error) bool {
+	e := p.primary()
+	p.recordHealth(e, 0, err)
+	if e == nil {
+		return p.failover()
+	}
+	if h, _ := p.getHealth(e); h.Up {
+		return false
+	}
+	return p.failover()
+}
@@ -40,12 +40,9 @@ func (p *Pool) HouseKeeping() {
 	for _, e := range p.exchangers {
 		slots := p.getAllSlots(e)
 		for _, slot := range slots {
-			fs, err := e.ReadDir(path.Join(p.Name, FeedsFolder, slot), 0)
-			if core.IsErr(err, "cannot read content in pool %s/%s", e, p.Name) {
-				continue
-			}
-			for _, f := range fs {
-				name := f.Name()
+			it := storage.NewDirIterator(e, path.Join(p.Name, FeedsFolder, slot), storage.ListFilter{})
+			for it.Next() {
+				name := it.Info().Name()
 
 				ext := filepath.Ext(name)
 				name = name[0 : len(name)-len(ext)]
@@ -62,9 +59,17 @@ func (p *Pool) HouseKeeping() {
 					deletedFiles++
 				}
 			}
+			core.IsErr(it.Err(), "cannot read content in pool %s/%s: %v", e, p.Name)
+		}
+		if !storage.IsReadOnly(e) {
+			deletedFiles += p.deleteOldChunks(e)
 		}
 	}
 
+	if e := p.primary(); e != nil && !storage.IsReadOnly(e) {
+		deletedFiles += p.deleteExpiredShares(e)
+	}
+
 	err := sqlDelFeedBefore(p.Name, int64(thresoldId))
 	core.IsErr(err, "cannot delete feeds from DB with id < %d", thresoldId)
 	core.Info("housekeeping completed with %d files deleted in %v", deletedFiles, core.Since(start))
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/code-to-go/safepool/core"
@@ -16,89 +20,153 @@ import (
 	"github.com/godruoyi/go-snowflake"
 )
 
+// Send uploads the content of r to the pool with the provided name and meta
 func (p *Pool) Send(name string, r io.ReadSeekCloser, size int64, meta []byte) (Head, error) {
+	return p.SendWithProgress(name, r, size, meta, nil)
+}
+
+// SendWithProgress is like Send and reports on progress the bytes uploaded since the previous report. The
+// upload is also listed in Transfers until it ends
+func (p *Pool) SendWithProgress(name string, r io.ReadSeekCloser, size int64, meta []byte, progress chan int64) (Head, error) {
 	id := snowflake.ID()
 	slot := core.Now().Format(FeedDateFormat)
 	n := path.Join(p.Name, FeedsFolder, slot, fmt.Sprintf("%d.body", id))
-	h, err := p.writeFile(p.e, n, r, size)
-	if core.IsErr(err, "cannot post file %s to %s: %v", name, p.e) {
-		return Head{}, err
+
+	targets := p.writeTargets()
+	if len(targets) > 1 {
+		return p.sendToAll(id, slot, name, r, size, meta, targets, progress)
+	}
+	e := p.primary()
+	if len(targets) == 1 {
+		e = targets[0]
+	}
+	if e == nil {
+		return Head{}, ErrNoStorage
 	}
 
-	hash := h.Sum(nil)
-	signature, err := security.Sign(p.Self, hash)
-	if core.IsErr(err, "cannot sign file %s.body in %s: %v", name, p.e) {
+	chunked := p.chunked(size)
+	reports, end := p.startTransfer(Transfer{Id: id, Name: name, Upload: true, Size: size + security.AESHeaderSize}, progress)
+	h, compression, err := p.upload(p.throttle(e, false), n, name, r, size, chunked, reports)
+	end()
+	if core.IsErr(err, "cannot post file %s to %s: %v", name, e) {
 		return Head{}, err
 	}
-	f := Head{
-		Id:        id,
-		Name:      name,
-		Size:      size,
-		Hash:      hash,
-		ModTime:   core.Now(),
-		AuthorId:  p.Self.Id(),
-		Signature: signature,
-		Meta:      meta,
-		Slot:      slot,
-		CTime:     core.Now().Unix(),
+
+	f, err := p.newHead(id, slot, name, size, meta, h, compression, chunked)
+	if err != nil {
+		return Head{}, err
 	}
-	data, err := json.Marshal(f)
-	if core.IsErr(err, "cannot marshal header to json: %v") {
+	err = p.writeHead(e, f, n)
+	if err != nil {
 		return Head{}, err
 	}
+	f.Writes = []WriteStatus{{Exchange: e.String(), Ok: true}}
 
-	hr := core.NewBytesReader(data)
-	hn := path.Join(p.Name, FeedsFolder, slot, fmt.Sprintf("%d.head", id))
-	_, err = p.writeFile(p.e, hn, hr, int64(len(data)))
-	if core.IsErr(err, "cannot write header %s.head in %s: %v", name, p.e) {
-		p.e.Delete(n)
+	core.Info("file '%s' sent to exchange '%s': id '%d', size '%d', hash '%s'", name, e, id,
+		size, base64.StdEncoding.EncodeToString(f.Hash))
+	return f, nil
+}
+
+// sendToAll encrypts the content of r into a temporary folder and then uploads it to all targets in parallel
+func (p *Pool) sendToAll(id uint64, slot string, name string, r io.ReadSeekCloser, size int64, meta []byte,
+	targets []storage.Storage, progress chan int64) (Head, error) {
+	dir, err := os.MkdirTemp("", "safepool-send-*")
+	if core.IsErr(err, "cannot create staging folder for %s: %v", name) {
 		return Head{}, err
 	}
-
-	tn := path.Join(p.Name, FeedsFolder, ".touch")
-	err = storage.WriteFile(p.e, tn, nil)
-	if core.IsErr(err, "cannot set touch file %s in %s: %v", n, p.e) {
+	defer os.RemoveAll(dir)
+	local, err := storage.OpenLocal("file://" + filepath.ToSlash(dir))
+	if core.IsErr(err, "cannot open staging folder for %s: %v", name) {
 		return Head{}, err
 	}
+	defer local.Close()
 
-	core.Info("file '%s' sent to exchange '%s': id '%d', size '%d', hash '%s'", name, p.e, id,
-		size, base64.StdEncoding.EncodeToString(hash))
+	n := path.Join(p.Name, FeedsFolder, slot, fmt.Sprintf("%d.body", id))
+	chunked := p.chunked(size)
+	h, compression, err := p.upload(local, n, name, r, size, chunked, nil)
+	if core.IsErr(err, "cannot stage file %s: %v", name) {
+		return Head{}, err
+	}
+	f, err := p.newHead(id, slot, name, size, meta, h, compression, chunked)
+	if err != nil {
+		return Head{}, err
+	}
 
-	// go func() {
-	// 	p.mutex.Lock()
-	// 	defer p.mutex.Unlock()
+	total := (size + security.AESHeaderSize) * int64(len(targets))
+	reports, end := p.startTransfer(Transfer{Id: id, Name: name, Upload: true, Size: total}, progress)
+	f.Writes, err = p.fanOut(dir, n, f, targets, reports)
+	end()
+	if err != nil {
+		return Head{}, err
+	}
 
-	// 	for _, e := range p.exchangers {
-	// 		if e == p.e {
-	// 			continue
-	// 		}
+	core.Info("file '%s' sent to %d exchanges: id '%d', size '%d', hash '%s'", name, len(targets), id,
+		size, base64.StdEncoding.EncodeToString(f.Hash))
+	return f, nil
+}
 
-	// 		r.Seek(0, 0)
-	// 		hr.Seek(0, 0)
+// chunked returns true when content of the given size must be sent as chunks
+func (p *Pool) chunked(size int64) bool {
+	return p.ChunkThreshold > 0 && size >= p.ChunkThreshold
+}
 
-	// 		_, err = p.writeFile(e, n, r, size)
-	// 		if core.IsErr(err, "cannot send %s to secondary exchange %e: %v", n, e) {
-	// 			continue
-	// 		}
-	// 		_, err = p.writeFile(e, hn, hr, int64(len(data)))
-	// 		if core.IsErr(err, "cannot send %s to secondary exchange %e: %v", hn, e) {
-	// 			continue
-	// 		}
-	// 		tn := path.Join(p.Name, FeedsFolder, ".touch")
-	// 		err = storage.WriteFile(e, tn, nil)
-	// 		core.IsErr(err, "cannot set checkpoint in %s: %v", p.e)
-	// 		core.Info("file '%s' sent to exchange '%s': id '%d', size '%d', hash '%s'", name, e, id,
-	// 			size, base64.StdEncoding.EncodeToString(hash))
+// newHead signs the hash of the content and returns the head of a new feed
+func (p *Pool) newHead(id uint64, slot string, name string, size int64, meta []byte, h hash.Hash, compression string,
+	chunked bool) (Head, error) {
+	hash := h.Sum(nil)
+	signature, err := security.Sign(p.Self, hash)
+	if core.IsErr(err, "cannot sign file %s.body: %v", name) {
+		return Head{}, err
+	}
+	return Head{
+		Id:          id,
+		Name:        name,
+		Size:        size,
+		Hash:        hash,
+		ModTime:     core.Now(),
+		AuthorId:    p.Self.Id(),
+		Signature:   signature,
+		Meta:        meta,
+		Slot:        slot,
+		CTime:       core.Now().Unix(),
+		Compression: compression,
+		Chunked:     chunked,
+	}, nil
+}
 
-	// 	}
+// writeHead writes the head of a feed whose body is already in the exchange e and updates the touch file. The
+// body is deleted when the head cannot be written
+func (p *Pool) writeHead(e storage.Storage, f Head, bodyName string) error {
+	f.Writes = nil
+	data, err := json.Marshal(f)
+	if core.IsErr(err, "cannot marshal header to json: %v") {
+		return err
+	}
 
-	// 	r.Close()
-	// }()
+	hr := core.NewBytesReader(data)
+	hn := path.Join(p.Name, FeedsFolder, f.Slot, fmt.Sprintf("%d.head", f.Id))
+	_, _, err = p.writeFile(e, hn, hr, int64(len(data)), NoCompression, nil)
+	if core.IsErr(err, "cannot write header %s.head in %s: %v", f.Name, e) {
+		e.Delete(bodyName)
+		return err
+	}
 
-	return f, nil
+	tn := path.Join(p.Name, FeedsFolder, ".touch")
+	err = storage.WriteFile(e, tn, nil)
+	if core.IsErr(err, "cannot set touch file %s in %s: %v", bodyName, e) {
+		return err
+	}
+	return nil
 }
 
+// Receive downloads the content of the file with the provided id into w
 func (p *Pool) Receive(id uint64, rang *storage.Range, w io.Writer) error {
+	return p.ReceiveWithProgress(id, rang, w, nil)
+}
+
+// ReceiveWithProgress is like Receive and reports on progress the bytes downloaded since the previous report.
+// The download is also listed in Transfers until it ends. Content served from the local cache is not reported
+func (p *Pool) ReceiveWithProgress(id uint64, rang *storage.Range, w io.Writer, progress chan int64) error {
 	f, err := sqlGetFeed(p.Name, id)
 	if core.IsErr(err, "cannot retrieve %d from pool %v: %v", id, p) {
 		return err
@@ -115,10 +183,37 @@ func (p *Pool) Receive(id uint64, rang *storage.Range, w io.Writer) error {
 		w = cw
 	}
 
-	hr, err := p.readFile(p.e, bodyName, rang, w)
+	size := f.Size + security.AESHeaderSize
+	if rang != nil {
+		size = rang.To - rang.From
+	}
+	reports, end := p.startTransfer(Transfer{Id: id, Name: f.Name, Size: size}, progress)
+	// a secondary is tried only when nothing was written, e.g. a chunk can be missing after other chunks
+	primary := p.primary()
+	counter := &countingWriter{w: w}
+	hr, err := p.readBody(primary, f, bodyName, rang, counter, reports)
+	if errors.Is(err, fs.ErrNotExist) && counter.n == 0 {
+		for _, e := range p.exchangers {
+			if e != primary {
+				core.Info("body '%s' missing on primary, try %s", bodyName, e)
+				hr, err = p.readBody(e, f, bodyName, rang, counter, reports)
+			}
+			if !errors.Is(err, fs.ErrNotExist) || counter.n > 0 {
+				break
+			}
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		p.failed(err)
+	}
+	end()
 	if core.IsErr(err, "cannot read body '%s': %v", bodyName) {
 		return err
 	}
+	if hr == nil {
+		// the chunks in a range of a chunked body are verified one by one
+		core.Info("received range of file with id %d from pool '%s'", id, p.Name)
+		return nil
+	}
 	hash := hr.Sum(nil)
 	if !bytes.Equal(hash, f.Hash) {
 		core.IsErr(security.ErrInvalidSignature, "mismatch between declared hash '%s' and actual hash '%s' in '%s'", f.Hash, hash, bodyName)
@@ -129,22 +224,70 @@ func (p *Pool) Receive(id uint64, rang *storage.Range, w io.Writer) error {
 	return nil
 }
 
-func (p *Pool) writeFile(e storage.Storage, name string, r io.ReadSeekCloser, size int64) (hash.Hash, error) {
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readBody reads the body of f from e. It returns a nil hash when rang is not nil and the body is chunked
+func (p *Pool) readBody(e storage.Storage, f Head, bodyName string, rang *storage.Range, w io.Writer,
+	progress chan int64) (hash.Hash, error) {
+	if f.Chunked {
+		return p.readChunks(p.throttle(e, false), bodyName, rang, w, progress)
+	}
+	return p.readFile(p.throttle(e, false), bodyName, rang, w, f.Compression, progress)
+}
+
+// writeFile encrypts and uploads the content of r. The content is compressed with the provided algorithm
+// unless it does not shrink; the returned compression is the one actually applied. The hash is computed
+// on the plain content
+func (p *Pool) writeFile(e storage.Storage, name string, r io.ReadSeekCloser, size int64, compression string,
+	progress chan int64) (hash.Hash, string, error) {
 	hr, err := security.NewHashReader(r)
 	if core.IsErr(err, "cannot create hash reader: %v") {
-		return nil, err
+		return nil, "", err
 	}
 
-	er, err := security.EncryptingReader(p.masterKeyId, p.keyFunc, hr)
+	var source io.ReadSeekCloser = hr
+	if compression != NoCompression {
+		c, csize, err := compress(compression, hr, size)
+		if err != nil {
+			return nil, "", err
+		}
+		defer c.Close()
+
+		if csize < size {
+			source, size = c, csize
+		} else {
+			_, err = hr.Seek(0, io.SeekStart)
+			if core.IsErr(err, "cannot rewind %s after compression: %v", name) {
+				return nil, "", err
+			}
+			compression = NoCompression
+		}
+	}
+
+	er, err := security.EncryptingReader(p.masterKeyId, p.keyFunc, source)
 	if core.IsErr(err, "cannot create encrypting reader: %v") {
-		return nil, err
+		return nil, "", err
 	}
 
-	err = e.Write(name, er, size+security.AESHeaderSize, nil)
-	return hr.Hash, err
+	err = e.Write(name, er, size+security.AESHeaderSize, progress)
+	return hr.Hash, compression, err
 }
 
-func (p *Pool) readFile(e storage.Storage, name string, rang *storage.Range, w io.Writer) (hash.Hash, error) {
+func (p *Pool) readFile(e storage.Storage, name string, rang *storage.Range, w io.Writer, compression string,
+	progress chan int64) (hash.Hash, error) {
+	if compression != NoCompression {
+		return p.readCompressedFile(e, name, rang, w, compression, progress)
+	}
+
 	hw, err := security.NewHashWriter(w)
 	if core.IsErr(err, "cannot create hash stream: %v") {
 		return nil, err
@@ -154,7 +297,43 @@ func (p *Pool) readFile(e storage.Storage, name string, rang *storage.Range, w i
 	if core.IsErr(err, "cannot create decrypting writer: %v") {
 		return nil, err
 	}
-	err = e.Read(name, rang, ew, nil)
+	err = e.Read(name, rang, ew, progress)
+	return hw.Hash, err
+}
+
+// readCompressedFile downloads and decrypts the whole body, since a range applies to the decompressed
+// content. The hash is computed on the whole decompressed content
+func (p *Pool) readCompressedFile(e storage.Storage, name string, rang *storage.Range, w io.Writer, compression string,
+	progress chan int64) (hash.Hash, error) {
+	hw, err := security.NewHashWriter(newRangeWriter(w, rang))
+	if core.IsErr(err, "cannot create hash stream: %v") {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		dr, err := newDecompressor(compression, pr)
+		if err == nil {
+			_, err = io.Copy(hw, dr)
+			dr.Close()
+		}
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	ew, err := security.DecryptingWriter(p.keyFunc, pw)
+	if core.IsErr(err, "cannot create decrypting writer: %v") {
+		pw.CloseWithError(err)
+		<-done
+		return nil, err
+	}
+	err = e.Read(name, nil, ew, progress)
+	pw.CloseWithError(err)
+	if derr := <-done; err == nil {
+		err = derr
+	}
+	core.IsErr(err, "cannot decompress %s with %s: %v", name, compression)
 	return hw.Hash, err
 }
 
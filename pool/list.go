@@ -19,7 +19,7 @@ import (
 
 func (p *Pool) readHead(e storage.Storage, name string) (Head, error) {
 	var b bytes.Buffer
-	_, err := p.readFile(e, name, nil, &b)
+	_, err := p.readFile(e, name, nil, &b, NoCompression, nil)
 	if core.IsErr(err, "cannot read header of %s in %s: %v", name, e) {
 		return Head{}, err
 	}
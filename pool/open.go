@@ -19,10 +19,13 @@ func Open(self security.Identity, name string) (*Pool, error) {
 	}
 
 	p := &Pool{
-		Name:          name,
-		Self:          self,
-		Apps:          config.Apps,
-		LifeSpanHours: core.If(config.LifeSpanHours > 0, config.LifeSpanHours, 24*30),
+		Name:           name,
+		Self:           self,
+		Apps:           config.Apps,
+		LifeSpanHours:  core.If(config.LifeSpanHours > 0, config.LifeSpanHours, 24*30),
+		WriteQuorum:    config.WriteQuorum,
+		ChunkThreshold: config.ChunkThreshold,
+		Compression:    config.Compression,
 
 		lastAccessSync: core.Now(),
 		lastReplica:    core.Now(),
@@ -59,5 +62,6 @@ func Open(self security.Identity, name string) (*Pool, error) {
 	}
 
 	p.startReplica()
+	p.startOutbox()
 	return p, nil
 }
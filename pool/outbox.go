@@ -0,0 +1,321 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/security"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/godruoyi/go-snowflake"
+)
+
+// OutboxStatus is the delivery status of an item in the outbox
+type OutboxStatus int
+
+const (
+	OutboxPending OutboxStatus = iota
+	OutboxSent
+)
+
+var ErrAlreadySent = errors.New("the item in the outbox has been already sent")
+var ErrOutboxBusy = errors.New("the item in the outbox is being delivered")
+
+// OutboxPath is the folder for the encrypted bodies in the outbox. When empty, a folder in the user data
+// folder is used
+var OutboxPath string
+
+// OutboxRetention is how long sent items stay in the outbox, so that callers can check their delivery
+var OutboxRetention = 24 * time.Hour
+
+// OutboxMinBackoff and OutboxMaxBackoff limit the delay between two attempts to deliver an item. The delay
+// doubles at every failed attempt
+var OutboxMinBackoff = 5 * time.Second
+var OutboxMaxBackoff = 10 * time.Minute
+
+// OutboxClaimSpan is how long a delivery reserves an item, so that other instances of the pool do not deliver
+// it at the same time. The claim of a process that ended during a delivery expires after this time
+var OutboxClaimSpan = time.Hour
+
+// OutboxItem is a feed kept in the local outbox until it is delivered to the primary exchange. Head is the
+// pending head until the first attempt, which assigns the id and slot of the delivered feed
+type OutboxItem struct {
+	Id       uint64       `json:"id"`
+	Head     Head         `json:"head"`
+	Status   OutboxStatus `json:"status"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	Next     time.Time    `json:"next"`
+	Updated  time.Time    `json:"updated"`
+}
+
+func (p *Pool) openOutbox() (storage.Storage, error) {
+	dir, err := p.outboxDir()
+	if err != nil {
+		return nil, err
+	}
+	return storage.OpenLocal("file://" + filepath.ToSlash(dir))
+}
+
+// outboxDir returns the local folder of the outbox and creates it when missing
+func (p *Pool) outboxDir() (string, error) {
+	dir := OutboxPath
+	if dir == "" {
+		dir = filepath.Join(xdg.DataHome, "safepool", "outbox")
+	}
+	dir = filepath.Join(dir, p.Self.Id(), filepath.FromSlash(p.Name))
+	err := os.MkdirAll(dir, 0755)
+	if core.IsErr(err, "cannot create outbox folder '%s': %v", dir) {
+		return "", err
+	}
+	return dir, nil
+}
+
+func outboxBody(id uint64) string {
+	return fmt.Sprintf("%d.body", id)
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	d := OutboxMinBackoff
+	for i := 1; i < attempts && d < OutboxMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > OutboxMaxBackoff {
+		d = OutboxMaxBackoff
+	}
+	return d
+}
+
+// Enqueue stores the content of r encrypted in the local outbox and returns immediately the pending head. The
+// feed is delivered in background when the primary exchange is reachable; the delivered head has a new id
+// and it is available in Outbox
+func (p *Pool) Enqueue(name string, r io.ReadSeekCloser, size int64, meta []byte) (Head, error) {
+	o, err := p.openOutbox()
+	if err != nil {
+		return Head{}, err
+	}
+	defer o.Close()
+
+	id := snowflake.ID()
+	compression := p.chooseCompression(name, r, size)
+	h, compression, err := p.writeFile(o, outboxBody(id), r, size, compression, nil)
+	if core.IsErr(err, "cannot add file %s to outbox of %s: %v", name, p.Name) {
+		return Head{}, err
+	}
+
+	hash := h.Sum(nil)
+	signature, err := security.Sign(p.Self, hash)
+	if core.IsErr(err, "cannot sign file %s in outbox: %v", name) {
+		o.Delete(outboxBody(id))
+		return Head{}, err
+	}
+	f := Head{
+		Id:          id,
+		Name:        name,
+		Size:        size,
+		Hash:        hash,
+		ModTime:     core.Now(),
+		AuthorId:    p.Self.Id(),
+		Signature:   signature,
+		Meta:        meta,
+		CTime:       core.Now().Unix(),
+		Compression: compression,
+	}
+
+	err = sqlSetOutboxItem(p.Name, OutboxItem{Id: id, Head: f, Status: OutboxPending, Next: core.Now(),
+		Updated: core.Now()})
+	if core.IsErr(err, "cannot save outbox item for %s: %v", name) {
+		o.Delete(outboxBody(id))
+		return Head{}, err
+	}
+
+	core.Info("file '%s' added to outbox of pool '%s' with id %d", name, p.Name, id)
+	p.triggerOutbox()
+	return f, nil
+}
+
+// Outbox returns the items in the outbox with their delivery status
+func (p *Pool) Outbox() ([]OutboxItem, error) {
+	return sqlGetOutbox(p.Name)
+}
+
+// RetryOutbox makes a pending item due for delivery immediately
+func (p *Pool) RetryOutbox(id uint64) error {
+	i, err := sqlGetOutboxItem(p.Name, id)
+	if core.IsErr(err, "cannot get outbox item %d: %v", id) {
+		return err
+	}
+	if i.Status != OutboxPending {
+		return ErrAlreadySent
+	}
+	ok, err := sqlRetryOutboxItem(p.Name, id, core.Now())
+	if core.IsErr(err, "cannot update outbox item %d: %v", id) {
+		return err
+	}
+	if !ok {
+		return ErrAlreadySent
+	}
+	p.triggerOutbox()
+	return nil
+}
+
+// CancelOutbox removes a pending item from the outbox. Items already sent cannot be cancelled and items being
+// delivered fail with ErrOutboxBusy
+func (p *Pool) CancelOutbox(id uint64) error {
+	i, err := sqlGetOutboxItem(p.Name, id)
+	if core.IsErr(err, "cannot get outbox item %d: %v", id) {
+		return err
+	}
+	if i.Status != OutboxPending {
+		return ErrAlreadySent
+	}
+	ok, err := sqlCancelOutboxItem(p.Name, id)
+	if core.IsErr(err, "cannot remove outbox item %d: %v", id) {
+		return err
+	}
+	if !ok {
+		return ErrOutboxBusy
+	}
+
+	o, err := p.openOutbox()
+	if err == nil {
+		o.Delete(outboxBody(id))
+		o.Close()
+	}
+	return nil
+}
+
+// flushOutbox delivers the pending items that are due and removes the sent items older than OutboxRetention.
+// An item is claimed in the db before its delivery, so that it is not delivered twice by instances of the
+// pool that flush at the same time
+func (p *Pool) flushOutbox() {
+	items, err := sqlGetOutbox(p.Name)
+	if err != nil || len(items) == 0 {
+		return
+	}
+	o, err := p.openOutbox()
+	if err != nil {
+		return
+	}
+	defer o.Close()
+
+	for _, i := range items {
+		switch {
+		case i.Status == OutboxSent && core.Since(i.Updated) > OutboxRetention:
+			err = sqlDelOutboxItem(p.Name, i.Id)
+			core.IsErr(err, "cannot remove sent item %d from outbox: %v", i.Id)
+		case i.Status == OutboxPending && !core.Now().Before(i.Next):
+			p.flushOutboxItem(o, i.Id)
+		}
+	}
+}
+
+// flushOutboxItem claims and delivers a pending item. The item is read again after the claim, since another
+// instance may have changed it
+func (p *Pool) flushOutboxItem(o storage.Storage, id uint64) {
+	ok, err := sqlClaimOutboxItem(p.Name, id, core.Now().Add(OutboxClaimSpan))
+	if core.IsErr(err, "cannot claim outbox item %d: %v", id) || !ok {
+		return
+	}
+	defer func() {
+		core.IsErr(sqlReleaseOutboxItem(p.Name, id), "cannot release outbox item %d: %v", id)
+	}()
+	i, err := sqlGetOutboxItem(p.Name, id)
+	if core.IsErr(err, "cannot get outbox item %d: %v", id) {
+		return
+	}
+
+	err = p.deliver(&i)
+	i.Attempts++
+	i.Updated = core.Now()
+	if err == nil {
+		i.Status, i.Error = OutboxSent, ""
+		o.Delete(outboxBody(i.Id))
+	} else {
+		i.Error = err.Error()
+		i.Next = core.Now().Add(outboxBackoff(i.Attempts))
+	}
+	err = sqlSetOutboxItem(p.Name, i)
+	core.IsErr(err, "cannot update outbox item %d: %v", i.Id)
+}
+
+// deliver uploads the body of an outbox item and writes its head to the exchanges of the pool. On the first
+// attempt the feed gets a new id and slot, so that members that already synced the current slot do not miss
+// it. They are saved before the upload and reused by the next attempts, so that an attempt whose result is
+// lost does not leave a duplicate feed in the pool
+func (p *Pool) deliver(i *OutboxItem) error {
+	targets := p.writeTargets()
+	if len(targets) == 0 {
+		return ErrNoStorage
+	}
+	dir, err := p.outboxDir()
+	if err != nil {
+		return err
+	}
+
+	if i.Head.Slot == "" {
+		i.Head.Id = snowflake.ID()
+		i.Head.Slot = core.Now().Format(FeedDateFormat)
+		i.Head.CTime = core.Now().Unix()
+		err = sqlSetOutboxItem(p.Name, *i)
+		if core.IsErr(err, "cannot save delivery id of outbox item %d: %v", i.Id) {
+			return err
+		}
+	}
+
+	f := i.Head
+	f.Writes, err = p.fanOut(dir, outboxBody(i.Id), f, targets, nil)
+	if core.IsErr(err, "cannot deliver outbox item %d: %v", i.Id) {
+		return err
+	}
+
+	core.Info("outbox item %d delivered to %d exchanges with id %d", i.Id, len(targets), f.Id)
+	i.Head = f
+	return nil
+}
+
+func (p *Pool) startOutbox() {
+	p.quitOutbox = make(chan struct{})
+	p.outboxDone = make(chan struct{})
+	p.flushOutboxNow = make(chan struct{}, 1)
+	quit, done, now := p.quitOutbox, p.outboxDone, p.flushOutboxNow
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(OutboxMinBackoff)
+		defer ticker.Stop()
+		for {
+			p.flushOutbox()
+			select {
+			case <-ticker.C:
+			case <-now:
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// stopOutbox stops the delivery of the outbox and waits for the end of a delivery in progress
+func (p *Pool) stopOutbox() {
+	if p.quitOutbox != nil {
+		close(p.quitOutbox)
+		<-p.outboxDone
+		p.quitOutbox, p.outboxDone = nil, nil
+	}
+}
+
+func (p *Pool) triggerOutbox() {
+	if p.flushOutboxNow == nil {
+		return
+	}
+	select {
+	case p.flushOutboxNow <- struct{}{}:
+	default:
+	}
+}
@@ -34,6 +34,7 @@ var ErrAlreadyExist = errors.New("pool already exists")
 var ErrInvalidToken = errors.New("provided token is invalid: missing name or configs")
 var ErrInvalidId = errors.New("provided id not a valid ed25519 public key")
 var ErrInvalidConfig = errors.New("provided config is invalid: missing name or configs")
+var ErrMissingKey = errors.New("encryption key is not available")
 var ErrInvalidName = errors.New("provided pool has invalid name")
 var ErrNoSyncClock = errors.New("cannot sync with global time server")
 
@@ -43,23 +44,42 @@ type Consumer interface {
 }
 
 type Config struct {
-	Name          string   `json:"name"`
-	Public        []string `json:"public"`
+	Name   string   `json:"name"`
+	Public []string `json:"public"`
+	// Private are the exchanges configured on this device. They are not shared in invites and their urls can
+	// refer to the secret store with secret://name
 	Private       []string `json:"private"`
 	Apps          []string `json:"apps"`
 	LifeSpanHours int      `json:"lifeSpan"`
+	// MetaCache caches Stat and ReadDir on the exchanges in the format of storage.ParseCacheConfig, e.g.
+	// 1m,feeds=10s. It helps with exchanges with high latency; empty disables the cache
+	MetaCache string `json:"metaCache,omitempty"`
+	// WriteQuorum is the number of exchanges that must store a feed for Send to succeed. The default is 1
+	WriteQuorum int `json:"writeQuorum,omitempty"`
+	// ChunkThreshold is the size from which content is sent as chunks, so that a new version of a large file
+	// uploads only the chunks that changed. Members with an older version cannot read chunked feeds, so the
+	// default 0 disables chunking
+	ChunkThreshold int64 `json:"chunkThreshold,omitempty"`
+	// Compression is the algorithm for compressible content, e.g. zstd. Members with an older version cannot
+	// read compressed feeds, so the default NoCompression disables compression
+	Compression string `json:"compression,omitempty"`
 }
 
 type Pool struct {
-	Name          string            `json:"name"`
-	Id            uint64            `json:"id"`
-	Self          security.Identity `json:"self"`
-	Apps          []string          `json:"apps"`
-	LifeSpanHours int               `json:"lifeSpanHours"`
-	Trusted       bool              `json:"trusted"`
-	Connection    string            `json:"connection"`
+	Name           string            `json:"name"`
+	Id             uint64            `json:"id"`
+	Self           security.Identity `json:"self"`
+	Apps           []string          `json:"apps"`
+	LifeSpanHours  int               `json:"lifeSpanHours"`
+	Trusted        bool              `json:"trusted"`
+	Connection     string            `json:"connection"`
+	WriteQuorum    int               `json:"writeQuorum"`
+	ChunkThreshold int64             `json:"chunkThreshold"`
+	Compression    string            `json:"compression"`
 
 	e                  storage.Storage
+	primaryMutex       sync.RWMutex
+	primaryChanged     chan struct{}
 	exchangers         []storage.Storage
 	masterKeyId        uint64
 	masterKey          []byte
@@ -68,8 +88,22 @@ type Pool struct {
 	lastReplica        time.Time
 	lastReplicaSlot    string
 	quitReplica        chan bool
+	repairs            []*repair
+	repairsMutex       sync.Mutex
+	health             healthBook
+	quitWatch          chan struct{}
+	quitOutbox         chan struct{}
+	outboxDone         chan struct{}
+	flushOutboxNow     chan struct{}
 	ctime              int64
 	mutex              sync.Mutex
+	transfers          []*Transfer
+	transfersMutex     sync.Mutex
+	foreground         *storage.Limiter
+	background         *storage.Limiter
+	rates              *Rates
+	ratesLoaded        bool
+	limitersMutex      sync.Mutex
 }
 
 type Head struct {
@@ -81,8 +115,14 @@ type Head struct {
 	AuthorId  string    `json:"authorId"`
 	Signature []byte    `json:"signature"`
 	Meta      []byte    `json:"meta"`
-	CTime     int64     `json:"-"`
-	Slot      string    `json:"-"`
+	// Compression is the algorithm applied to the content before encryption; empty means none
+	Compression string `json:"compression,omitempty"`
+	// Chunked is true when the body is a manifest of chunks stored in ChunksFolder
+	Chunked bool `json:"chunked,omitempty"`
+	// Writes reports the result of Send on each exchange. It is not stored in the exchange
+	Writes []WriteStatus `json:"writes,omitempty"`
+	CTime  int64         `json:"-"`
+	Slot   string        `json:"-"`
 }
 
 const (
@@ -114,6 +154,8 @@ func (p *Pool) List(ctime int64) ([]Head, error) {
 func (p *Pool) Close() {
 	p.mutex.Lock()
 	p.stopReplica()
+	p.stopWatch()
+	p.stopOutbox()
 	for _, e := range p.exchangers {
 		_ = e.Close()
 	}
@@ -143,7 +185,7 @@ func (p *Pool) Leave() error {
 }
 
 func (p *Pool) ToString() string {
-	return fmt.Sprintf("%s [%v]", p.Name, p.e)
+	return fmt.Sprintf("%s [%v]", p.Name, p.primary())
 }
 
 var ctimeLock sync.Mutex
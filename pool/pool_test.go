@@ -1,9 +1,17 @@
 package pool
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +20,9 @@ import (
 	"github.com/code-to-go/safepool/core"
 	"github.com/code-to-go/safepool/security"
 	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/godruoyi/go-snowflake"
+	"github.com/google/uuid"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
@@ -135,3 +146,639 @@ func TestSafeReplica(t *testing.T) {
 
 	fmt.Printf("creation: %s, post: %s\n", creationTime, postTime)
 }
+
+func TestTransfers(t *testing.T) {
+	p := &Pool{}
+	progress := make(chan int64, 2)
+	reports, end := p.startTransfer(Transfer{Id: 1, Name: "test.txt", Upload: true, Size: 100}, progress)
+
+	reports <- 40
+	assert.EqualValues(t, 40, <-progress)
+	ts := p.Transfers()
+	assert.Len(t, ts, 1)
+	assert.EqualValues(t, 40, ts[0].Done)
+	assert.EqualValues(t, 100, ts[0].Size)
+
+	end()
+	assert.Len(t, p.Transfers(), 0)
+}
+
+func TestRates(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	p := &Pool{Name: "test.safepool.net/rates"}
+	AvailableBandwidth = LowBandwidth
+	defer func() { AvailableBandwidth = HighBandwith }()
+	assert.Equal(t, BandwidthRates[LowBandwidth], p.Rates())
+
+	p.throttle(nil, true)
+	assert.Equal(t, BandwidthRates[LowBandwidth].Background, p.background.Rate())
+
+	assert.NoError(t, p.SetRates(&Rates{Foreground: 1000}))
+	assert.Equal(t, Rates{Foreground: 1000}, p.Rates())
+	p.throttle(nil, false)
+	assert.EqualValues(t, 1000, p.foreground.Rate())
+
+	assert.NoError(t, p.SetRates(nil))
+	assert.Equal(t, BandwidthRates[LowBandwidth], p.Rates())
+
+	// rates are read from the db only once
+	assert.NoError(t, sql.SetConfig("pool/"+p.Name, ratesKey, "", 0, []byte(`{"foreground":5}`)))
+	assert.Equal(t, BandwidthRates[LowBandwidth], p.Rates())
+	assert.Equal(t, Rates{Foreground: 5}, (&Pool{Name: p.Name}).Rates())
+}
+
+func TestCompression(t *testing.T) {
+	e, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e.Close()
+
+	p := &Pool{Name: "test.safepool.net/compression", masterKeyId: 1, masterKey: security.GenerateBytesKey(32)}
+	data := bytes.Repeat([]byte(`{"text":"hello world"}`), 100)
+	assert.Equal(t, NoCompression, p.chooseCompression("chat.json", core.NewBytesReader(data), int64(len(data))),
+		"compression must be off by default")
+	p.Compression = ZstdCompression
+	assert.Equal(t, ZstdCompression, p.chooseCompression("chat.json", core.NewBytesReader(data), int64(len(data))))
+	assert.Equal(t, NoCompression, p.chooseCompression("photo.jpg", core.NewBytesReader(security.GenerateBytesKey(1024)), 1024))
+
+	for _, c := range []string{GzipCompression, ZstdCompression} {
+		h, compression, err := p.writeFile(e, c, core.NewBytesReader(data), int64(len(data)), c, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, c, compression)
+		stat, err := e.Stat(c)
+		assert.NoError(t, err)
+		assert.Less(t, stat.Size(), int64(len(data)))
+
+		var b bytes.Buffer
+		hr, err := p.readFile(e, c, nil, &b, compression, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, data, b.Bytes())
+		assert.Equal(t, h.Sum(nil), hr.Sum(nil))
+
+		b.Reset()
+		_, err = p.readFile(e, c, &storage.Range{From: 10, To: 30}, &b, compression, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, data[10:30], b.Bytes())
+	}
+
+	noise := security.GenerateBytesKey(1024)
+	_, compression, err := p.writeFile(e, "noise", core.NewBytesReader(noise), 1024, ZstdCompression, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, NoCompression, compression)
+}
+
+func TestChunks(t *testing.T) {
+	e, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e.Close()
+
+	ChunkSplitBits = 10
+	defer func() { ChunkSplitBits = 20 }()
+
+	p := &Pool{Name: "test.safepool.net/chunks", LifeSpanHours: 24, masterKeyId: 1,
+		masterKey: security.GenerateBytesKey(32)}
+	data := security.GenerateBytesKey(64 * 1024)
+	h, err := p.writeChunks(e, "v1", core.NewBytesReader(data), nil)
+	assert.NoError(t, err)
+	chunks, err := e.ReadDir(path.Join(p.Name, ChunksFolder), 0)
+	assert.NoError(t, err)
+	count := len(chunks)
+	assert.Greater(t, count, 8)
+
+	var b bytes.Buffer
+	hr, err := p.readChunks(e, "v1", nil, &b, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b.Bytes())
+	assert.Equal(t, h.Sum(nil), hr.Sum(nil))
+
+	data[32*1024] ^= 0xff
+	_, err = p.writeChunks(e, "v2", core.NewBytesReader(data), nil)
+	assert.NoError(t, err)
+	chunks, err = e.ReadDir(path.Join(p.Name, ChunksFolder), 0)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(chunks), count+3)
+
+	b.Reset()
+	hr, err = p.readChunks(e, "v2", &storage.Range{From: 30 * 1024, To: 40 * 1024}, &b, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, hr)
+	assert.Equal(t, data[30*1024:40*1024], b.Bytes())
+}
+
+func TestOutbox(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+	OutboxPath = t.TempDir()
+	defer func() { OutboxPath = "" }()
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	p := &Pool{Name: "test.safepool.net/outbox", Self: self, masterKeyId: 1, masterKey: security.GenerateBytesKey(32)}
+
+	data := []byte("hello outbox")
+	h, err := p.Enqueue("hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	h2, err := p.Enqueue("cancel.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+
+	e, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e.Close()
+	offline := storage.NewChaos(e, storage.ChaosConfig{ErrorRate: 1}).(*storage.Chaos)
+	p.e = offline
+
+	p.flushOutbox()
+	items, err := p.Outbox()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, OutboxPending, items[0].Status)
+	assert.Equal(t, 1, items[0].Attempts)
+	assert.NotEmpty(t, items[0].Error)
+	assert.True(t, items[0].Next.After(core.Now()))
+	deliveryId := items[0].Head.Id
+	assert.NotEqual(t, h.Id, deliveryId, "the delivery id must be assigned before the first attempt")
+
+	// an item claimed by another instance is neither delivered nor cancelled
+	assert.NoError(t, p.RetryOutbox(h2.Id))
+	ok, err := sqlClaimOutboxItem(p.Name, h2.Id, core.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sqlClaimOutboxItem(p.Name, h2.Id, core.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, ok, "an item can be claimed only once")
+	assert.ErrorIs(t, p.CancelOutbox(h2.Id), ErrOutboxBusy)
+	offline.SetConfig(storage.ChaosConfig{})
+	p.flushOutbox()
+	i2, err := sqlGetOutboxItem(p.Name, h2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, i2.Attempts)
+	assert.NoError(t, sqlReleaseOutboxItem(p.Name, h2.Id))
+	assert.NoError(t, p.CancelOutbox(h2.Id))
+
+	assert.NoError(t, p.RetryOutbox(h.Id))
+	p.flushOutbox()
+
+	items, err = p.Outbox()
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, OutboxSent, items[0].Status)
+	assert.Equal(t, 2, items[0].Attempts)
+	assert.Equal(t, deliveryId, items[0].Head.Id, "a retry must reuse the delivery id")
+	assert.ErrorIs(t, p.CancelOutbox(h.Id), ErrAlreadySent)
+
+	f := items[0].Head
+	var b bytes.Buffer
+	hr, err := p.readFile(p.e, path.Join(p.Name, FeedsFolder, f.Slot, fmt.Sprintf("%d.body", f.Id)), nil, &b,
+		f.Compression, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b.Bytes())
+	assert.Equal(t, h.Hash, hr.Sum(nil))
+
+	_, err = p.e.Stat(path.Join(p.Name, FeedsFolder, f.Slot, fmt.Sprintf("%d.head", f.Id)))
+	assert.NoError(t, err)
+}
+
+func TestShare(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	p := &Pool{Name: "test.safepool.net/share", Self: self, masterKeyId: 1, masterKey: security.GenerateBytesKey(32)}
+	l, err := storage.OpenStorage("file://" + t.TempDir())
+	assert.NoError(t, err)
+	defer l.Close()
+	_, err = storage.Presign(l, "hello.txt", time.Hour)
+	assert.ErrorIs(t, err, storage.ErrNotSupported)
+	p.e = newPresigning(t, l)
+
+	data := bytes.Repeat([]byte("hello share "), 100)
+	h, err := p.Send("docs/hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sqlAddFeed(p.Name, h))
+
+	link, err := p.Share(h.Id, time.Hour)
+	assert.NoError(t, err)
+	var b bytes.Buffer
+	info, err := ReadShare(link, &b)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b.Bytes())
+	assert.Equal(t, "hello.txt", info.Name)
+	assert.Equal(t, int64(len(data)), info.Size)
+
+	u, _, _ := strings.Cut(link, "#")
+	_, err = ReadShare(u, &b)
+	assert.ErrorIs(t, err, ErrInvalidShareLink)
+	_, err = ReadShare(strings.Replace(link, "h=", "h=AAAA", 1), io.Discard)
+	assert.ErrorIs(t, err, security.ErrInvalidSignature)
+
+	expired := path.Join(p.Name, SharesFolder, fmt.Sprintf("%d-1.body", core.Now().Add(-time.Minute).Unix()))
+	assert.NoError(t, storage.WriteFile(p.e, expired, data))
+	assert.Equal(t, 1, p.deleteExpiredShares(p.e))
+	_, err = ReadShare(link, io.Discard)
+	assert.NoError(t, err)
+
+	m, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer m.Close()
+	p.e = m
+	_, err = p.Share(h.Id, time.Hour)
+	assert.ErrorIs(t, err, storage.ErrNotSupported)
+	_, err = m.ReadDir(path.Join(p.Name, SharesFolder), 0)
+	assert.True(t, os.IsNotExist(err), "nothing must be uploaded when the exchange cannot presign")
+}
+
+func TestWriteQuorum(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	e1, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e1.Close()
+	e2, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e2.Close()
+
+	ChunkSplitBits = 10
+	defer func() { ChunkSplitBits = 20 }()
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	p := &Pool{Name: "test.safepool.net/quorum", Self: self, LifeSpanHours: 24, WriteQuorum: 2,
+		ChunkThreshold: 16 * 1024, masterKeyId: 1, masterKey: security.GenerateBytesKey(32)}
+	offline := storage.NewChaos(e2, storage.ChaosConfig{ErrorRate: 1}).(*storage.Chaos)
+	p.e = e1
+	p.exchangers = []storage.Storage{e1, offline}
+
+	data := bytes.Repeat([]byte("hello quorum "), 100)
+	_, err = p.Send("hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.Equal(t, 0, p.Repairs())
+	big := security.GenerateBytesKey(64 * 1024)
+	_, err = p.Send("big.bin", core.NewBytesReader(big), int64(len(big)), nil)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.Eventually(t, func() bool {
+		chunks, _ := e1.ReadDir(path.Join(p.Name, ChunksFolder), 0)
+		return len(chunks) == 0
+	}, 5*time.Second, 10*time.Millisecond, "the chunks of a send without quorum are removed")
+	assert.Eventually(t, func() bool {
+		ls, _ := e1.ReadDir(path.Join(p.Name, FeedsFolder), 0)
+		for _, l := range ls {
+			if slot, _ := e1.ReadDir(path.Join(p.Name, FeedsFolder, l.Name()), 0); l.IsDir() && len(slot) > 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond, "the body of a send without quorum is removed")
+	_, err = e1.Stat(path.Join(p.Name, FeedsFolder, ".touch"))
+	assert.Error(t, err, "no head is written without quorum")
+
+	p.WriteQuorum = 1
+	h, err := p.Send("hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	assert.Len(t, h.Writes, 2)
+	assert.True(t, h.Writes[0].Ok)
+	assert.False(t, h.Writes[1].Ok)
+	assert.NotEmpty(t, h.Writes[1].Error)
+
+	hb, err := p.Send("big.bin", core.NewBytesReader(big), int64(len(big)), nil)
+	assert.NoError(t, err)
+	assert.True(t, hb.Chunked)
+	assert.Equal(t, 2, p.Repairs())
+
+	// repairs are retried while the exchange is offline
+	backoff := OutboxMinBackoff
+	OutboxMinBackoff = 0
+	defer func() { OutboxMinBackoff = backoff }()
+	p.runRepairs()
+	assert.Equal(t, 2, p.Repairs())
+
+	offline.SetConfig(storage.ChaosConfig{})
+	p.runRepairs()
+	assert.Equal(t, 0, p.Repairs())
+
+	var b bytes.Buffer
+	_, err = p.readFile(e2, path.Join(p.Name, FeedsFolder, h.Slot, fmt.Sprintf("%d.body", h.Id)), nil, &b,
+		h.Compression, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b.Bytes())
+	_, err = e2.Stat(path.Join(p.Name, FeedsFolder, h.Slot, fmt.Sprintf("%d.head", h.Id)))
+	assert.NoError(t, err)
+
+	b.Reset()
+	_, err = p.readChunks(e2, path.Join(p.Name, FeedsFolder, hb.Slot, fmt.Sprintf("%d.body", hb.Id)), nil, &b, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, big, b.Bytes())
+
+	// a hung exchange does not delay Send once the quorum is reached
+	hung := &blocking{Storage: e2, release: make(chan struct{})}
+	p.exchangers = []storage.Storage{e1, hung}
+	done := make(chan Head)
+	go func() {
+		h, err := p.Send("hung.txt", core.NewBytesReader(data), int64(len(data)), nil)
+		assert.NoError(t, err)
+		done <- h
+	}()
+	select {
+	case h = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send waits for a hung exchange")
+	}
+	assert.True(t, h.Writes[0].Ok)
+	assert.False(t, h.Writes[1].Ok)
+	assert.Equal(t, 1, p.Repairs())
+
+	close(hung.release)
+	p.runRepairs()
+	assert.Equal(t, 0, p.Repairs())
+	_, err = e2.Stat(path.Join(p.Name, FeedsFolder, h.Slot, fmt.Sprintf("%d.head", h.Id)))
+	assert.NoError(t, err)
+}
+
+// blocking is a storage whose writes wait until release is closed
+// presigning serves the files of a storage over http to test share links
+type presigning struct {
+	storage.Storage
+	url string
+}
+
+func newPresigning(t *testing.T, s storage.Storage) *presigning {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := s.Read(strings.TrimPrefix(r.URL.Path, "/"), nil, w, nil)
+		if err != nil {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &presigning{s, srv.URL}
+}
+
+func (p *presigning) Presign(name string, expiry time.Duration) (string, error) {
+	return p.url + "/" + name, nil
+}
+
+type blocking struct {
+	storage.Storage
+	release chan struct{}
+}
+
+func (b *blocking) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	<-b.release
+	return b.Storage.Write(name, source, size, progress)
+}
+
+func TestFailover(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	e1, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e1.Close()
+	e2, err := storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer e2.Close()
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	p := &Pool{Name: "test.safepool.net/failover", Self: self, LifeSpanHours: 24, WriteQuorum: 2, masterKeyId: 1,
+		masterKey: security.GenerateBytesKey(32)}
+	p.exchangers = []storage.Storage{e1, e2}
+	p.findPrimary()
+	assert.NotNil(t, p.e)
+	for _, h := range p.Health() {
+		assert.True(t, h.Up)
+		assert.Greater(t, h.Score, 0.0)
+	}
+
+	// a body missing on the primary is read from a secondary
+	data := []byte("hello failover")
+	h, err := p.Send("hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, sqlAddFeed(p.Name, h))
+	assert.NoError(t, p.e.Delete(path.Join(p.Name, FeedsFolder, h.Slot, fmt.Sprintf("%d.body", h.Id))))
+	var b bytes.Buffer
+	assert.NoError(t, p.Receive(h.Id, nil, &b))
+	assert.Equal(t, data, b.Bytes())
+
+	// the primary goes down
+	down := storage.NewChaos(e2, storage.ChaosConfig{ErrorRate: 1})
+	p.exchangers = []storage.Storage{e1, down}
+	p.setPrimary(down)
+	interval := storage.MinPollInterval
+	storage.MinPollInterval = 10 * time.Millisecond
+	defer func() { storage.MinPollInterval = interval }()
+	heads, err := p.Heads()
+	assert.NoError(t, err)
+	defer p.stopWatch()
+	p.checkHealth()
+	assert.Equal(t, down, p.e, "a single failure does not switch the primary")
+	for i := 1; i < FailoverErrors; i++ {
+		p.checkHealth()
+	}
+	assert.Equal(t, e1, p.e)
+	assert.Equal(t, e1.String(), p.Connection)
+
+	hs := p.Health()
+	assert.True(t, hs[0].Primary)
+	assert.False(t, hs[1].Up)
+	assert.Equal(t, 0.0, hs[1].Score)
+	assert.NotEmpty(t, hs[1].LastError)
+
+	// the watch follows the new primary
+	other, err := security.NewIdentity("other")
+	assert.NoError(t, err)
+	assert.NoError(t, security.SetIdentity(other))
+	q := &Pool{Name: p.Name, Self: other, LifeSpanHours: 24, masterKeyId: 1, masterKey: p.masterKey}
+	q.e = e1
+	h, err = q.Send("other.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	select {
+	case got := <-heads:
+		assert.Equal(t, h.Id, got.Id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification from the new primary")
+	}
+}
+
+func TestSyncLateHead(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	assert.NoError(t, security.SetIdentity(self))
+	p := &Pool{Name: "test.safepool.net/late", Self: self, LifeSpanHours: 24, masterKeyId: 1,
+		masterKey: security.GenerateBytesKey(32)}
+	p.e, err = storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer p.e.Close()
+
+	data := []byte("hello")
+	slow, err := p.Send("slow.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	fast, err := p.Send("fast.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+
+	// the head of the first feed becomes visible after the second feed is synced
+	hn := path.Join(p.Name, FeedsFolder, slow.Slot, fmt.Sprintf("%d.head", slow.Id))
+	head, err := storage.ReadFile(p.e, hn)
+	assert.NoError(t, err)
+	assert.NoError(t, p.e.Delete(hn))
+
+	hs, err := p.syncFeeds()
+	assert.NoError(t, err)
+	assert.Len(t, hs, 1)
+	assert.Equal(t, fast.Id, hs[0].Id)
+
+	assert.NoError(t, storage.WriteFile(p.e, hn, head))
+	hs, err = p.syncFeeds()
+	assert.NoError(t, err)
+	ids := map[uint64]bool{}
+	for _, h := range hs {
+		ids[h.Id] = true
+	}
+	assert.True(t, ids[slow.Id])
+
+	// heads older than the overlap window are not listed again
+	old := fast.Id - uint64(SyncOverlap.Milliseconds())<<(snowflake.SequenceLength+snowflake.MachineIDLength) - 1
+	on := path.Join(p.Name, FeedsFolder, slow.Slot, fmt.Sprintf("%d.head", old))
+	assert.NoError(t, storage.WriteFile(p.e, on, head))
+	hs, err = p.syncFeeds()
+	assert.NoError(t, err)
+	assert.Len(t, hs, 2, "a head before the overlap window must not be listed")
+}
+
+func TestReceiveFallbackChunks(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	ChunkSplitBits, CacheSizeMB = 10, 0
+	defer func() { ChunkSplitBits, CacheSizeMB = 20, 16 }()
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	p := &Pool{Name: "test.safepool.net/fallback", Self: self, LifeSpanHours: 24, WriteQuorum: 2,
+		ChunkThreshold: 16 * 1024, masterKeyId: 1, masterKey: security.GenerateBytesKey(32)}
+	for i := 0; i < 2; i++ {
+		e, err := storage.OpenStorage("mem://" + uuid.New().String())
+		assert.NoError(t, err)
+		defer e.Close()
+		p.exchangers = append(p.exchangers, e)
+	}
+	p.setPrimary(p.exchangers[0])
+
+	data := security.GenerateBytesKey(64 * 1024)
+	h, err := p.Send("big.bin", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	assert.True(t, h.Chunked)
+	assert.NoError(t, sqlAddFeed(p.Name, h))
+
+	var b bytes.Buffer
+	_, err = p.readFile(p.primary(), path.Join(p.Name, FeedsFolder, h.Slot, fmt.Sprintf("%d.body", h.Id)), nil, &b,
+		NoCompression, nil)
+	assert.NoError(t, err)
+	var m Manifest
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &m))
+	assert.Greater(t, len(m.Chunks), 2)
+
+	// a chunk missing after others are written fails instead of writing the content twice
+	assert.NoError(t, p.primary().Delete(p.chunkName(m.Chunks[len(m.Chunks)-1].Hash)))
+	b.Reset()
+	assert.Error(t, p.Receive(h.Id, nil, &b))
+	assert.Less(t, b.Len(), len(data))
+
+	// a chunk missing before anything is written is read from the secondary
+	assert.NoError(t, p.primary().Delete(p.chunkName(m.Chunks[0].Hash)))
+	b.Reset()
+	assert.NoError(t, p.Receive(h.Id, nil, &b))
+	assert.Equal(t, data, b.Bytes())
+}
+
+// exportZip copies the files of dir in e into a new zip file and returns its path
+func exportZip(t *testing.T, e storage.Storage, dir string) string {
+	name := filepath.Join(t.TempDir(), "pool.zip")
+	f, err := os.Create(name)
+	assert.NoError(t, err)
+	defer f.Close()
+	z := zip.NewWriter(f)
+	defer z.Close()
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		ls, err := e.ReadDir(dir, storage.IncludeHiddenFiles)
+		assert.NoError(t, err)
+		for _, l := range ls {
+			n := path.Join(dir, l.Name())
+			if l.IsDir() {
+				walk(n)
+				continue
+			}
+			data, err := storage.ReadFile(e, n)
+			assert.NoError(t, err)
+			w, err := z.Create(n)
+			assert.NoError(t, err)
+			w.Write(data)
+		}
+	}
+	walk(dir)
+	return name
+}
+
+func TestArchive(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	self, err := security.NewIdentity("test")
+	assert.NoError(t, err)
+	assert.NoError(t, security.SetIdentity(self))
+	masterKey := security.GenerateBytesKey(32)
+	p := &Pool{Name: "test.safepool.net/archive", Self: self, LifeSpanHours: 24, masterKeyId: 1,
+		masterKey: masterKey}
+	p.e, err = storage.OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+	defer p.e.Close()
+
+	data := bytes.Repeat([]byte("hello archive "), 100)
+	h, err := p.Send("hello.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.NoError(t, err)
+	name := exportZip(t, p.e, p.Name)
+
+	a := &Pool{Name: p.Name, Self: self, LifeSpanHours: 24, masterKeyId: 1, masterKey: masterKey,
+		lastAccessSync: core.Now()}
+	assert.NoError(t, a.connectSafe(Config{Name: p.Name, Public: []string{"archive://" + name}}))
+	defer a.Close()
+	assert.True(t, storage.IsReadOnly(a.primary()))
+
+	hs, err := a.Sync()
+	assert.NoError(t, err)
+	assert.Len(t, hs, 1)
+	assert.Equal(t, h.Id, hs[0].Id)
+
+	var b bytes.Buffer
+	assert.NoError(t, a.Receive(h.Id, nil, &b))
+	assert.Equal(t, data, b.Bytes())
+
+	_, err = a.Send("other.txt", core.NewBytesReader(data), int64(len(data)), nil)
+	assert.Error(t, err, "an archived pool is read only")
+}
+
+func TestDumpNoPrimary(t *testing.T) {
+	assert.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	assert.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	p := &Pool{Name: "test.safepool.net/dump"}
+	m := p.Dump()
+	assert.Equal(t, p.Name, m["pool"])
+	assert.NotContains(t, m, "primary")
+	assert.ErrorIs(t, p.SyncAccess(false), ErrNoStorage)
+}
@@ -0,0 +1,341 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/storage"
+)
+
+// WriteStatus is the result of the write of a feed to an exchange
+type WriteStatus struct {
+	Exchange string `json:"exchange"`
+	Ok       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+var ErrNoQuorum = errors.New("the feed has not been written to enough exchanges")
+
+// RepairMaxAttempts is the number of attempts to copy a feed to an exchange where Send failed. Afterwards the
+// copy is left to the periodic replica
+var RepairMaxAttempts = 10
+
+// repair is a feed to copy from an exchange where Send succeeded to one where it failed
+type repair struct {
+	source   storage.Storage
+	target   storage.Storage
+	names    []string
+	attempts int
+	next     time.Time
+}
+
+// writeTargets returns the exchanges that accept writes, with the primary first
+func (p *Pool) writeTargets() []storage.Storage {
+	var targets []storage.Storage
+	primary := p.primary()
+	if primary != nil && !storage.IsReadOnly(primary) {
+		targets = append(targets, primary)
+	}
+	for _, e := range p.exchangers {
+		if e != primary && !storage.IsReadOnly(e) {
+			targets = append(targets, e)
+		}
+	}
+	return targets
+}
+
+// quorum returns the number of successful writes required out of n exchanges. A quorum larger than n
+// requires all the exchanges
+func (p *Pool) quorum(n int) int {
+	q := p.WriteQuorum
+	if q < 1 {
+		q = 1
+	}
+	if q > n {
+		q = n
+	}
+	return q
+}
+
+// upload encrypts the content of r to name in e, as chunks when chunked is true
+func (p *Pool) upload(e storage.Storage, name string, fileName string, r io.ReadSeekCloser, size int64, chunked bool,
+	progress chan int64) (hash.Hash, string, error) {
+	if chunked {
+		h, err := p.writeChunks(e, name, r, progress)
+		return h, NoCompression, err
+	}
+	compression := p.chooseCompression(fileName, r, size)
+	return p.writeFile(e, name, r, size, compression, progress)
+}
+
+// errInFlight is the status of a target whose write did not complete when the quorum was reached
+var errInFlight = errors.New("the write was still in progress when the quorum was reached")
+
+// bodyWrite is the result of the upload of a body to the target i. Chunks are the chunks created by the upload
+type bodyWrite struct {
+	i      int
+	chunks []string
+	err    error
+}
+
+// fanOut uploads the body staged in dir, the chunks staged in dir when the feed is chunked and the head of f
+// to all targets concurrently. Heads are written as soon as the body is stored on the quorum of targets, so
+// that members never see a feed that is then removed and a slow target does not delay Send. When the quorum
+// is not reached, the bodies and the chunks already written are removed and fanOut fails with ErrNoQuorum.
+// The targets where the write fails or is still in progress are repaired in background
+func (p *Pool) fanOut(dir string, staged string, f Head, targets []storage.Storage, progress chan int64) ([]WriteStatus, error) {
+	bodyName := path.Join(p.Name, FeedsFolder, f.Slot, fmt.Sprintf("%d.body", f.Id))
+	headName := path.Join(p.Name, FeedsFolder, f.Slot, fmt.Sprintf("%d.head", f.Id))
+
+	var chunks []string
+	if f.Chunked {
+		ls, err := os.ReadDir(filepath.Join(dir, filepath.FromSlash(p.Name), ChunksFolder))
+		if core.IsErr(err, "cannot read staged chunks of %s: %v", f.Name) {
+			return nil, err
+		}
+		for _, l := range ls {
+			chunks = append(chunks, path.Join(p.Name, ChunksFolder, l.Name()))
+		}
+	}
+
+	statuses := make([]WriteStatus, len(targets))
+	for i, e := range targets {
+		statuses[i] = WriteStatus{Exchange: e.String(), Error: errInFlight.Error()}
+	}
+	created := make([][]string, len(targets))
+	collected := make([]bool, len(targets))
+	stored, failed := 0, 0
+	collect := func(w bodyWrite) {
+		created[w.i], collected[w.i] = w.chunks, true
+		if w.err != nil {
+			statuses[w.i].Error = w.err.Error()
+			failed++
+		} else {
+			statuses[w.i].Ok, statuses[w.i].Error = true, ""
+			stored++
+		}
+	}
+
+	// writes that end after the decision are not collected. They remove what they wrote when the quorum fails
+	var mutex sync.Mutex
+	var decided, reached, ended bool
+	defer func() {
+		mutex.Lock()
+		ended = true
+		mutex.Unlock()
+	}()
+	relay := func() chan int64 {
+		if progress == nil {
+			return nil
+		}
+		ch := make(chan int64)
+		go func() {
+			for n := range ch {
+				mutex.Lock()
+				if !ended {
+					progress <- n
+				}
+				mutex.Unlock()
+			}
+		}()
+		return ch
+	}
+
+	results := make(chan bodyWrite, len(targets))
+	for i, e := range targets {
+		go func(i int, e storage.Storage) {
+			reports := relay()
+			own, err := p.writeBody(e, dir, chunks, staged, bodyName, reports)
+			if reports != nil {
+				close(reports)
+			}
+
+			mutex.Lock()
+			late, removed := decided, decided && !reached
+			if !late {
+				results <- bodyWrite{i, own, err}
+			}
+			mutex.Unlock()
+			if removed {
+				deleteBody(e, bodyName, own)
+			}
+		}(i, e)
+	}
+
+	q := p.quorum(len(targets))
+	for stored < q && len(targets)-failed >= q {
+		collect(<-results)
+	}
+	mutex.Lock()
+	decided, reached = true, stored >= q
+	for len(results) > 0 {
+		collect(<-results)
+	}
+	mutex.Unlock()
+
+	if !reached {
+		for i, e := range targets {
+			if collected[i] {
+				deleteBody(e, bodyName, created[i])
+			}
+		}
+		core.IsErr(ErrNoQuorum, "feed %d stored on %d of %d exchanges in pool %s: %v", f.Id, stored,
+			len(targets), p.Name)
+		return statuses, ErrNoQuorum
+	}
+
+	p.forTargets(targets, statuses, func(i int, e storage.Storage) {
+		if err := p.writeHead(e, f, bodyName); err != nil {
+			statuses[i].Ok, statuses[i].Error = false, err.Error()
+		}
+	})
+
+	var source storage.Storage
+	var pending []storage.Storage
+	for i, s := range statuses {
+		if s.Ok && source == nil {
+			source = targets[i]
+		} else if !s.Ok {
+			pending = append(pending, targets[i])
+		}
+	}
+	if source == nil {
+		core.IsErr(ErrNoQuorum, "cannot write head of feed %d in pool %s: %v", f.Id, p.Name)
+		return statuses, ErrNoQuorum
+	}
+
+	names := append(chunks, bodyName, headName)
+	for _, e := range pending {
+		p.addRepair(&repair{source: source, target: e, names: names, next: core.Now()})
+	}
+	return statuses, nil
+}
+
+// deleteBody removes the body of a feed and the chunks created by its upload
+func deleteBody(e storage.Storage, bodyName string, chunks []string) {
+	for _, cn := range chunks {
+		e.Delete(cn)
+	}
+	e.Delete(bodyName)
+}
+
+// forTargets calls op concurrently on the targets whose status is ok and waits for the end
+func (p *Pool) forTargets(targets []storage.Storage, statuses []WriteStatus, op func(i int, e storage.Storage)) {
+	var wg sync.WaitGroup
+	for i, e := range targets {
+		if !statuses[i].Ok {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, e storage.Storage) {
+			defer wg.Done()
+			op(i, e)
+		}(i, e)
+	}
+	wg.Wait()
+}
+
+// writeBody uploads the missing chunks and the body of a feed to e. It returns the chunks that did not exist
+// in e, also when it fails
+func (p *Pool) writeBody(e storage.Storage, dir string, chunks []string, staged string, bodyName string,
+	progress chan int64) ([]string, error) {
+	var created []string
+	lifeSpan := time.Duration(p.LifeSpanHours) * time.Hour
+	for _, cn := range chunks {
+		stat, err := e.Stat(cn)
+		if err == nil && core.Since(stat.ModTime()) < lifeSpan {
+			continue
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			created = append(created, cn)
+		}
+		err = p.writeLocalFile(e, cn, filepath.Join(dir, filepath.FromSlash(cn)), progress)
+		if core.IsErr(err, "cannot write chunk %s to %s: %v", cn, e) {
+			return created, err
+		}
+	}
+
+	err := p.writeLocalFile(e, bodyName, filepath.Join(dir, filepath.FromSlash(staged)), progress)
+	core.IsErr(err, "cannot write %s to %s: %v", bodyName, e)
+	return created, err
+}
+
+func (p *Pool) writeLocalFile(e storage.Storage, name string, localPath string, progress chan int64) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return p.throttle(e, false).Write(name, file, stat.Size(), progress)
+}
+
+func (p *Pool) addRepair(r *repair) {
+	p.repairsMutex.Lock()
+	defer p.repairsMutex.Unlock()
+	p.repairs = append(p.repairs, r)
+}
+
+// runRepairs copies the feeds that Send could not write to some exchanges. A failed copy is retried with
+// an exponential backoff up to RepairMaxAttempts
+func (p *Pool) runRepairs() {
+	p.repairsMutex.Lock()
+	repairs := p.repairs
+	p.repairs = nil
+	p.repairsMutex.Unlock()
+
+	var pending []*repair
+	for _, r := range repairs {
+		if core.Now().Before(r.next) {
+			pending = append(pending, r)
+			continue
+		}
+
+		for len(r.names) > 0 {
+			n := r.names[0]
+			err := storage.CopyFile(p.throttle(r.target, true), n, r.source, n)
+			if core.IsErr(err, "cannot repair '%s' on %s: %v", n, r.target) {
+				break
+			}
+			r.names = r.names[1:]
+		}
+		if len(r.names) == 0 {
+			tn := path.Join(p.Name, FeedsFolder, ".touch")
+			core.IsErr(storage.WriteFile(r.target, tn, nil), "cannot set touch file in %s: %v", r.target)
+			core.Info("repaired feed on %s in pool %s", r.target, p.Name)
+			continue
+		}
+
+		r.attempts++
+		if r.attempts >= RepairMaxAttempts {
+			core.Info("give up repair of '%s' on %s after %d attempts", r.names[len(r.names)-1], r.target, r.attempts)
+			continue
+		}
+		r.next = core.Now().Add(outboxBackoff(r.attempts))
+		pending = append(pending, r)
+	}
+
+	p.repairsMutex.Lock()
+	p.repairs = append(pending, p.repairs...)
+	p.repairsMutex.Unlock()
+}
+
+// Repairs returns the number of feeds waiting to be copied to exchanges where Send failed
+func (p *Pool) Repairs() int {
+	p.repairsMutex.Lock()
+	defer p.repairsMutex.Unlock()
+	return len(p.repairs)
+}
@@ -36,6 +36,10 @@ func (p *Pool) startReplica() {
 		for {
 			select {
 			case <-ticker.C:
+				p.runRepairs()
+				if core.Since(p.health.lastCheck) > HealthCheckPeriod {
+					p.checkHealth()
+				}
 				if core.Since(p.lastReplica) > HouseKeepingPeriods[AvailableBandwidth] {
 					p.replica()
 					p.lastReplica = core.Now()
@@ -58,22 +62,25 @@ func (p *Pool) replica() {
 	defer p.mutex.Unlock()
 
 	slots := p.listReplicaSlots()
+	primary := p.primary()
 	for _, e := range p.exchangers {
-		if e != p.e {
+		if e != primary {
 			for _, s := range slots {
-				err := p.syncContent(e, path.Join(FeedsFolder, s))
+				err := p.syncContent(primary, e, path.Join(FeedsFolder, s))
 				core.IsErr(err, "cannot sync slot %s for secondary %s during replica: %v", s, e)
 			}
-			err := p.syncContent(e, identityFolder)
+			err := p.syncContent(primary, e, identityFolder)
 			core.IsErr(err, "cannot sync identities for secondary %s during replica: %v", e)
+			err = p.syncContent(primary, e, ChunksFolder)
+			core.IsErr(err, "cannot sync chunks for secondary %s during replica: %v", e)
 		}
 	}
 	p.lastReplicaSlot = core.If(len(slots) > 0, slots[len(slots)-1], "")
 }
 
-func (p *Pool) syncContent(e storage.Storage, folder string) error {
+func (p *Pool) syncContent(primary storage.Storage, e storage.Storage, folder string) error {
 	folder = path.Join(p.Name, folder)
-	ls, _ := p.e.ReadDir(folder, 0)
+	ls, _ := primary.ReadDir(folder, 0)
 	m := map[string]bool{}
 	for _, l := range ls {
 		n := l.Name()
@@ -85,19 +92,22 @@ func (p *Pool) syncContent(e storage.Storage, folder string) error {
 	ls, _ = e.ReadDir(folder, 0)
 	for _, l := range ls {
 		n := l.Name()
-		if n[0] != '.' && !m[n] {
+		if n[0] != '.' && !m[n] && !storage.IsReadOnly(primary) {
 			fn := path.Join(folder, n)
-			err := storage.CopyFile(p.e, fn, e, fn)
+			err := storage.CopyFile(p.throttle(primary, true), fn, e, fn)
 			core.IsErr(err, "cannot clone '%s': %v", fn)
-			core.Info("copied '%s' from '%s' to '%s'", fn, e, p.e)
+			core.Info("copied '%s' from '%s' to '%s'", fn, e, primary)
 		}
 		delete(m, n)
 	}
 
+	if storage.IsReadOnly(e) {
+		return nil
+	}
 	for n := range m {
 		n = path.Join(folder, n)
-		err := storage.CopyFile(e, n, p.e, n)
-		core.Info("copied '%s' from '%s' to '%s'", p.e, e)
+		err := storage.CopyFile(p.throttle(e, true), n, primary, n)
+		core.Info("copied '%s' from '%s' to '%s'", n, primary, e)
 		core.IsErr(err, "cannot clone '%s': %v", n)
 	}
 
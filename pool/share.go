@@ -0,0 +1,192 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/security"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/godruoyi/go-snowflake"
+)
+
+// SharesFolder is the folder for the bodies of share links. Each body is encrypted with its own key
+const SharesFolder = "shares"
+
+var ErrInvalidShareLink = errors.New("share link is invalid or incomplete")
+
+// ShareTimeout is the longest time to download the content of a share link
+var ShareTimeout = 10 * time.Minute
+
+// ShareInfo describes the content of a share link
+type ShareInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash []byte `json:"hash"`
+}
+
+// Share creates a link to the content of the feed id that is valid for expiry. The content is encrypted with a
+// new key and uploaded to SharesFolder, so the pool key is never revealed. The link is a presigned url of the
+// exchange with the key and the hash in the fragment, which browsers and http clients do not send to servers.
+// It fails with storage.ErrNotSupported when the exchange cannot presign urls
+func (p *Pool) Share(id uint64, expiry time.Duration) (string, error) {
+	e := p.primary()
+	if e == nil {
+		return "", ErrNoStorage
+	}
+	if expiry <= 0 || expiry > storage.MaxPresignExpiry {
+		expiry = storage.MaxPresignExpiry
+	}
+	f, err := sqlGetFeed(p.Name, id)
+	if core.IsErr(err, "cannot retrieve %d from pool %v: %v", id, p) {
+		return "", err
+	}
+
+	// the url is signed before the upload, which is skipped when the exchange cannot presign
+	expires := core.Now().Add(expiry)
+	n := path.Join(p.Name, SharesFolder, fmt.Sprintf("%d-%d.body", expires.Unix(), snowflake.ID()))
+	u, err := storage.Presign(e, n, expiry)
+	if core.IsErr(err, "cannot presign share %s in %s: %v", n, e) {
+		return "", err
+	}
+
+	t, err := os.CreateTemp("", "safepool-share-*")
+	if core.IsErr(err, "cannot create temporary file for share: %v") {
+		return "", err
+	}
+	tf := tempFile{t}
+	defer tf.Close()
+
+	err = p.Receive(id, nil, tf)
+	if err != nil {
+		return "", err
+	}
+	_, err = tf.Seek(0, io.SeekStart)
+	if core.IsErr(err, "cannot rewind share of %d: %v", id) {
+		return "", err
+	}
+
+	key := security.GenerateBytesKey(32)
+	er, err := security.EncryptingReader(0, func(uint64) []byte { return key }, tf)
+	if core.IsErr(err, "cannot create encrypting reader: %v") {
+		return "", err
+	}
+
+	err = e.Write(n, er, f.Size+security.AESHeaderSize, nil)
+	if core.IsErr(err, "cannot write share %s to %s: %v", n, e) {
+		return "", err
+	}
+
+	fragment := url.Values{
+		"k": {base64.RawURLEncoding.EncodeToString(key)},
+		"h": {base64.RawURLEncoding.EncodeToString(f.Hash)},
+		"n": {path.Base(f.Name)},
+		"s": {strconv.FormatInt(f.Size, 10)},
+	}
+	core.Info("file with id %d in pool '%s' shared until %v", id, p.Name, expires)
+	return u + "#" + fragment.Encode(), nil
+}
+
+// ReadShare downloads the content of a share link into w, decrypts it and verifies its hash. It does not
+// require to be member of the pool
+func ReadShare(link string, w io.Writer) (ShareInfo, error) {
+	u, fragment, ok := strings.Cut(link, "#")
+	q, err := url.ParseQuery(fragment)
+	if !ok || err != nil || q.Get("k") == "" || q.Get("h") == "" {
+		core.IsErr(ErrInvalidShareLink, "missing key or hash in share link: %v")
+		return ShareInfo{}, ErrInvalidShareLink
+	}
+	key, err := base64.RawURLEncoding.DecodeString(q.Get("k"))
+	if err != nil {
+		return ShareInfo{}, ErrInvalidShareLink
+	}
+	hash, err := base64.RawURLEncoding.DecodeString(q.Get("h"))
+	if err != nil {
+		return ShareInfo{}, ErrInvalidShareLink
+	}
+	info := ShareInfo{Name: q.Get("n"), Hash: hash}
+	info.Size, _ = strconv.ParseInt(q.Get("s"), 10, 64)
+
+	r, err := openShareUrl(u)
+	if err != nil {
+		return ShareInfo{}, err
+	}
+	defer r.Close()
+
+	hw, err := security.NewHashWriter(w)
+	if core.IsErr(err, "cannot create hash stream: %v") {
+		return ShareInfo{}, err
+	}
+	ew, err := security.DecryptingWriter(func(uint64) []byte { return key }, hw)
+	if core.IsErr(err, "cannot create decrypting writer: %v") {
+		return ShareInfo{}, err
+	}
+	_, err = io.Copy(ew, r)
+	if core.IsErr(err, "cannot download share %s: %v", info.Name) {
+		return ShareInfo{}, err
+	}
+	if !bytes.Equal(hw.Hash.Sum(nil), hash) {
+		core.IsErr(security.ErrInvalidSignature, "content of share %s does not match its hash: %v", info.Name)
+		return ShareInfo{}, security.ErrInvalidSignature
+	}
+	return info, nil
+}
+
+func openShareUrl(link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if core.IsErr(err, "invalid share url: %v") {
+		return nil, ErrInvalidShareLink
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		client := &http.Client{Timeout: ShareTimeout}
+		res, err := client.Get(link)
+		if core.IsErr(err, "cannot download share: %v") {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			err = fmt.Errorf("share download failed with status %s", res.Status)
+			core.IsErr(err, "cannot download share: %v")
+			return nil, err
+		}
+		return res.Body, nil
+	default:
+		core.IsErr(ErrInvalidShareLink, "unsupported scheme '%s' in share link: %v", u.Scheme)
+		return nil, ErrInvalidShareLink
+	}
+}
+
+// deleteExpiredShares removes the bodies of share links whose expiry is past
+func (p *Pool) deleteExpiredShares(e storage.Storage) int {
+	now := core.Now().Unix()
+
+	var deleted int
+	it := storage.NewDirIterator(e, path.Join(p.Name, SharesFolder), storage.ListFilter{})
+	for it.Next() {
+		expires, _, _ := strings.Cut(it.Info().Name(), "-")
+		t, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || t > now {
+			continue
+		}
+		n := path.Join(p.Name, SharesFolder, it.Info().Name())
+		if !core.IsErr(e.Delete(n), "cannot delete share '%s' during housekeeping: %v", n) {
+			deleted++
+		}
+	}
+	if err := it.Err(); !os.IsNotExist(err) {
+		core.IsErr(err, "cannot read shares in pool %s/%s: %v", e, p.Name)
+	}
+	return deleted
+}
@@ -12,20 +12,35 @@ import (
 	"github.com/code-to-go/safepool/core"
 	"github.com/code-to-go/safepool/security"
 	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/godruoyi/go-snowflake"
 )
 
 const FeedsFolder = "feeds"
 const SyncAccessFrequency = 5 * time.Minute
 
-func (p *Pool) getSlots(last string) ([]string, error) {
-	fs, err := p.e.ReadDir(path.Join(p.Name, FeedsFolder), 0)
+// SyncOverlap is how far before the last synced head a slot is listed again, so that heads whose upload took
+// longer than the ones after them are not missed
+var SyncOverlap = 10 * time.Minute
+
+// syncStartAfter returns the name after which the heads of a slot are listed, given the last synced id
+func syncStartAfter(lastId int64) string {
+	overlap := SyncOverlap.Milliseconds() << (snowflake.SequenceLength + snowflake.MachineIDLength)
+	if lastId <= overlap {
+		return ""
+	}
+	return fmt.Sprintf("%d.head", lastId-overlap)
+}
+
+func (p *Pool) getSlots(e storage.Storage, last string) ([]string, error) {
+	fs, err := e.ReadDir(path.Join(p.Name, FeedsFolder), 0)
 	if os.IsNotExist(err) || core.IsErr(err, "cannot list slots in '%v': %v", p) {
 		return nil, err
 	}
 
 	var slots []string
 	for _, f := range fs {
-		if f.Name() >= last && f.IsDir() {
+		if f.Name() >= last && f.IsDir() && !storage.IsTemp(f.Name()) {
 			slots = append(slots, f.Name())
 		}
 	}
@@ -35,14 +50,17 @@ func (p *Pool) getSlots(last string) ([]string, error) {
 }
 
 func (p *Pool) syncFeeds() ([]Head, error) {
+	e := p.primary()
 	configNode := fmt.Sprintf("pool/%s", p.Name)
-	checkpointKey := fmt.Sprintf("checkpoints/%s", p.e.String())
-	slotKey := fmt.Sprintf("slots/%s", p.e.String())
+	checkpointKey := fmt.Sprintf("checkpoints/%s", e.String())
+	slotKey := fmt.Sprintf("slots/%s", e.String())
+	headKey := fmt.Sprintf("heads/%s", e.String())
 	_, lastCheckpoint, _, _ := sql.GetConfig(configNode, checkpointKey)
 	lastSlot, _, _, _ := sql.GetConfig(configNode, slotKey)
+	_, lastId, _, _ := sql.GetConfig(configNode, headKey)
 
 	var checkpoint int64
-	if stat, err := p.e.Stat(path.Join(p.Name, FeedsFolder, ".touch")); err == nil {
+	if stat, err := e.Stat(path.Join(p.Name, FeedsFolder, ".touch")); err == nil {
 		checkpoint = stat.ModTime().UnixMilli()
 	}
 	if lastCheckpoint > 0 && checkpoint <= lastCheckpoint {
@@ -56,7 +74,7 @@ func (p *Pool) syncFeeds() ([]Head, error) {
 		feeds[h.Id] = h
 	}
 
-	slots, err := p.getSlots(lastSlot)
+	slots, err := p.getSlots(e, lastSlot)
 	if err != nil {
 		core.Info("no slots, skip sync")
 		return nil, err
@@ -67,19 +85,27 @@ func (p *Pool) syncFeeds() ([]Head, error) {
 	idThresold := p.BaseId()
 	slotThresold := p.baseSlot()
 	for _, slot := range slots {
-		fs, err := p.e.ReadDir(path.Join(p.Name, FeedsFolder, slot), 0)
-		if core.IsErr(err, "cannot read content in slot %s in pool", slot, p) {
-			continue
-		}
+		folder := path.Join(p.Name, FeedsFolder, slot)
 		if slot < slotThresold {
-			for _, f := range fs {
-				p.e.Delete(f.Name())
+			it := storage.NewDirIterator(e, folder, storage.ListFilter{})
+			for it.Next() {
+				e.Delete(path.Join(folder, it.Info().Name()))
 			}
 			continue
 		}
-		core.Debug("%d files in folder %s/%s/%s", len(fs), p.Name, FeedsFolder, slot)
-		for _, f := range fs {
-			name := f.Name()
+
+		// the listing starts SyncOverlap before the last synced head since a head with a lower id can appear
+		// after a higher one, when its upload takes longer
+		files := 0
+		it := storage.NewDirIterator(e, folder, storage.ListFilter{StartAfter: syncStartAfter(lastId)})
+		maxId := lastId
+		for it.Next() {
+			files++
+			name := it.Info().Name()
+			if storage.IsTemp(name) {
+				core.Debug("file '%s' is being written; skip", name)
+				continue
+			}
 			if !strings.HasSuffix(name, ".head") {
 				core.Debug("file '%s' is not an header", name)
 				continue
@@ -90,20 +116,23 @@ func (p *Pool) syncFeeds() ([]Head, error) {
 				core.Debug("file '%s' has unexpected format", name)
 				continue
 			}
+			if id > maxId {
+				maxId = id
+			}
 			if _, found := feeds[uint64(id)]; found {
 				core.Debug("file '%s' has known id; skip", name)
 				continue
 			}
 
-			n := path.Join(p.Name, FeedsFolder, slot, name)
+			n := path.Join(folder, name)
 			if id < int64(idThresold) {
 				core.Debug("file '%s' has id %d lower than thresold %d; delete it", name, id, idThresold)
-				p.e.Delete(n)
+				e.Delete(n)
 				continue
 			}
 
-			f, err := p.readHead(p.e, n)
-			if core.IsErr(err, "cannot read file %s from %s: %v", n, p.e) {
+			f, err := p.readHead(e, n)
+			if core.IsErr(err, "cannot read file %s from %s: %v", n, e) {
 				skippedFeeds++
 				continue
 			}
@@ -121,9 +150,16 @@ func (p *Pool) syncFeeds() ([]Head, error) {
 			core.Debug("file '%s' has old id; skip", name)
 			hs = append(hs, f)
 		}
+		if core.IsErr(it.Err(), "cannot read content in slot %s in pool %s: %v", slot, p) {
+			continue
+		}
+		core.Debug("%d files listed in folder %s", files, folder)
+
 		lastSlot = slot
 		if skippedFeeds == 0 {
+			lastId = maxId
 			sql.SetConfig(configNode, slotKey, slot, 0, nil)
+			sql.SetConfig(configNode, headKey, "", lastId, nil)
 		}
 	}
 	core.Info("sync completed, %d new heads, pendingFeeds %d, slot '%s', modTime %d", len(hs), skippedFeeds,
@@ -137,7 +173,12 @@ func (p *Pool) Sync() ([]Head, error) {
 		p.lastAccessSync = core.Now()
 	}
 
+	p.mutex.Lock()
 	hs, err := p.syncFeeds()
+	if err != nil && p.failed(err) {
+		hs, err = p.syncFeeds()
+	}
+	p.mutex.Unlock()
 	if err != nil {
 		return nil, err
 	}
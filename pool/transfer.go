@@ -0,0 +1,67 @@
+package pool
+
+import (
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// Transfer is an upload or a download in progress between the pool and its primary exchange. Size and Done
+// are in bytes of the encrypted content
+type Transfer struct {
+	Id      uint64    `json:"id"`
+	Name    string    `json:"name"`
+	Upload  bool      `json:"upload"`
+	Size    int64     `json:"size"`
+	Done    int64     `json:"done"`
+	Started time.Time `json:"started"`
+}
+
+// Transfers returns the uploads and downloads in progress
+func (p *Pool) Transfers() []Transfer {
+	p.transfersMutex.Lock()
+	defer p.transfersMutex.Unlock()
+
+	transfers := make([]Transfer, 0, len(p.transfers))
+	for _, t := range p.transfers {
+		transfers = append(transfers, *t)
+	}
+	return transfers
+}
+
+// startTransfer registers a transfer and returns the progress channel to pass to the storage and a function
+// to call when the transfer ends. Reports are forwarded to progress when not nil
+func (p *Pool) startTransfer(t Transfer, progress chan int64) (chan int64, func()) {
+	t.Started = core.Now()
+	p.transfersMutex.Lock()
+	p.transfers = append(p.transfers, &t)
+	p.transfersMutex.Unlock()
+
+	reports := make(chan int64)
+	done := make(chan bool)
+	go func() {
+		for n := range reports {
+			p.transfersMutex.Lock()
+			t.Done += n
+			p.transfersMutex.Unlock()
+			if progress != nil {
+				progress <- n
+			}
+		}
+		close(done)
+	}()
+
+	return reports, func() {
+		close(reports)
+		<-done
+
+		p.transfersMutex.Lock()
+		defer p.transfersMutex.Unlock()
+		for i, o := range p.transfers {
+			if o == &t {
+				p.transfers = append(p.transfers[:i], p.transfers[i+1:]...)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package pool
+
+import (
+	"path"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/storage"
+)
+
+// Heads returns a channel that receives the heads sent by other members as soon as the primary exchange
+// notifies the change. Exchanges without notifications are polled. The watch moves to the new primary after
+// a failover. The channel is closed when the pool is closed or when Heads is called again
+func (p *Pool) Heads() (chan Head, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stopWatch()
+	changed := make(chan struct{}, 1)
+	p.primaryMutex.Lock()
+	p.primaryChanged = changed
+	p.primaryMutex.Unlock()
+
+	stop := make(chan struct{})
+	events, stopEvents, err := p.watchPrimary()
+	if err != nil {
+		return nil, err
+	}
+	p.quitWatch = stop
+
+	heads := make(chan Head)
+	go func() {
+		defer close(heads)
+		for {
+			select {
+			case <-stop:
+				close(stopEvents)
+				return
+			case <-changed:
+				close(stopEvents)
+				var err error
+				events, stopEvents, err = p.watchPrimary()
+				if err != nil {
+					return
+				}
+				continue
+			case _, ok := <-events:
+				if !ok {
+					close(stopEvents)
+					return
+				}
+			}
+
+			// a single sync serves a burst of notifications
+			for drained := false; !drained; {
+				select {
+				case _, ok := <-events:
+					drained = !ok
+				default:
+					drained = true
+				}
+			}
+
+			known := map[uint64]bool{}
+			hs, _ := p.List(0)
+			for _, h := range hs {
+				known[h.Id] = true
+			}
+
+			// the sync runs under the pool lock like Sync, HouseKeeping and Close
+			p.mutex.Lock()
+			select {
+			case <-stop:
+				p.mutex.Unlock()
+				close(stopEvents)
+				return
+			default:
+			}
+			hs, err := p.syncFeeds()
+			p.mutex.Unlock()
+			if core.IsErr(err, "cannot sync feeds of %s after a change: %v", p.Name) {
+				continue
+			}
+			for _, h := range hs {
+				if known[h.Id] || h.AuthorId == p.Self.Id() {
+					continue
+				}
+				select {
+				case heads <- h:
+				case <-stop:
+					close(stopEvents)
+					return
+				}
+			}
+		}
+	}()
+	return heads, nil
+}
+
+// watchPrimary returns the notifications of changes in the feeds of the primary exchange. They stop when the
+// returned channel is closed
+func (p *Pool) watchPrimary() (chan string, chan struct{}, error) {
+	e := p.primary()
+	stop := make(chan struct{})
+	events, err := storage.Watch(e, path.Join(p.Name, FeedsFolder), stop)
+	if core.IsErr(err, "cannot watch feeds of %s in %s: %v", p.Name, e) {
+		return nil, nil, err
+	}
+	return events, stop, nil
+}
+
+func (p *Pool) stopWatch() {
+	if p.quitWatch != nil {
+		close(p.quitWatch)
+		p.quitWatch = nil
+	}
+	p.primaryMutex.Lock()
+	p.primaryChanged = nil
+	p.primaryMutex.Unlock()
+}
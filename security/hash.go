@@ -73,12 +73,22 @@ func (s *HashReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// Seek moves the underlying reader. Seeking to the start resets the hash so that a source can be read again,
+// e.g. when a write is retried. Other positions would make the hash inconsistent with the content and fail with
+// os.ErrInvalid; only the current position can be queried
 func (s *HashReader) Seek(offset int64, whence int) (int64, error) {
 	if s.r == nil {
 		return 0, os.ErrClosed
-	} else {
-		return s.r.Seek(offset, whence)
 	}
+	switch {
+	case offset == 0 && whence == io.SeekStart:
+		s.Hash.Reset()
+		s.size = 0
+	case offset == 0 && whence == io.SeekCurrent:
+	default:
+		return 0, os.ErrInvalid
+	}
+	return s.r.Seek(offset, whence)
 }
 
 func (s *HashReader) Close() error {
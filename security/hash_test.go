@@ -27,3 +27,25 @@ func TestHashStream(t *testing.T) {
 
 	assert.Equal(t, hash, hw.Hash.Sum(nil))
 }
+
+func TestHashSeek(t *testing.T) {
+	b := make([]byte, 1024)
+	rand.Read(b)
+
+	hr, _ := NewHashReader(core.NewBytesReader(b))
+	io.CopyN(io.Discard, hr, 100)
+	pos, err := hr.Seek(0, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), pos)
+
+	_, err = hr.Seek(10, io.SeekStart)
+	assert.Error(t, err, "seeking in the middle must fail since the hash cannot be recomputed")
+
+	_, err = hr.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	io.Copy(io.Discard, hr)
+
+	hw, _ := NewHashWriter(io.Discard)
+	hw.Write(b)
+	assert.Equal(t, hw.Hash.Sum(nil), hr.Hash.Sum(nil))
+}
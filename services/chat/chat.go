@@ -101,11 +101,11 @@ func (c *Chat) SendMessage(contentType string, text string, binary []byte, priva
 		return 0, nil
 	}
 
-	go func() {
-		name := fmt.Sprintf("%s/%d.chat", c.Name, m.Id)
-		_, err = c.Pool.Send(name, core.NewBytesReader(data), int64(len(data)), meta)
-		core.IsErr(err, "cannot write chat message: %v")
-	}()
+	name := fmt.Sprintf("%s/%d.chat", c.Name, m.Id)
+	_, err = c.Pool.Enqueue(name, core.NewBytesReader(data), int64(len(data)), meta)
+	if core.IsErr(err, "cannot write chat message: %v") {
+		return 0, err
+	}
 
 	core.Info("added chat message with id %d", m.Id)
 	return m.Id, nil
@@ -313,6 +313,19 @@ func (l *Library) Receive(id uint64, localPath string) (File, error) {
 	return f, nil
 }
 
+// Share returns a link to the document with the provided id that is valid for expiry. The recipient reads it
+// with pool.ReadShare and does not need to join the pool
+func (l *Library) Share(id uint64, expiry time.Duration) (string, error) {
+	_, ok, err := sqlGetFileById(l.Pool.Name, l.Name, id)
+	if core.IsErr(err, "cannot get document with id '%d': %v", id) {
+		return "", err
+	}
+	if !ok {
+		return "", core.ErrInvalidId
+	}
+	return l.Pool.Share(id, expiry)
+}
+
 func (l *Library) Delete(id uint64) error {
 	return nil
 }
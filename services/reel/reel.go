@@ -56,6 +56,7 @@ func (r *Reel) Send(thread string, name string) error {
 	if core.IsErr(err, "cannot open file '%s': %v", name) {
 		return err
 	}
+	defer f.Close()
 
 	t, _ := thumbnail(f, 128, 128)
 	m, err := json.Marshal(meta{
@@ -68,12 +69,9 @@ func (r *Reel) Send(thread string, name string) error {
 	f.Seek(0, 0)
 
 	fs, _ := f.Stat()
-	go func() {
-		_, err = r.Pool.Send(path.Join(r.Name, thread, name), f, fs.Size(), m)
-		core.IsErr(err, "cannot send file to reel %s/%s/%s: %v", r.Pool, r.Name, thread)
-	}()
-
-	return nil
+	_, err = r.Pool.Enqueue(path.Join(r.Name, thread, name), f, fs.Size(), m)
+	core.IsErr(err, "cannot send file to reel %s/%s/%s: %v", r.Pool, r.Name, thread)
+	return err
 }
 
 func thumbnail(r io.ReadSeeker, w, h uint) ([]byte, error) {
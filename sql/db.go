@@ -34,3 +34,27 @@ func DelConfigs(node string) error {
 	core.IsErr(err, "cannot del configs %s", node)
 	return err
 }
+
+// GetConfigKeys returns the keys of all the configs in node
+func GetConfigKeys(node string) ([]string, error) {
+	rows, err := Query("GET_CONFIG_KEYS", Args{"node": node})
+	if core.IsErr(err, "cannot list configs for %s: %v", node) {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func DelConfig(node string, key string) error {
+	_, err := Exec("DEL_CONFIG_KEY", Args{"node": node, "key": key})
+	core.IsErr(err, "cannot del config %s/%s: %v", node, key)
+	return err
+}
@@ -41,6 +41,12 @@ func createTables() error {
 				logrus.Errorf("cannot execute SQL Init stmt (line %d) '%s': %v", line, ql, err)
 				return err
 			}
+		} else if strings.HasPrefix(key, "UPGRADE") {
+			// upgrades of existing databases fail when the change is already in place
+			_, err := db.Exec(ql)
+			if err != nil {
+				logrus.Debugf("SQL Upgrade stmt (line %d) '%s' not applied: %v", line, ql, err)
+			}
 		} else {
 			err := prepareStatement(key, ql, line)
 			if err != nil {
@@ -89,6 +95,11 @@ func OpenDB(dbPath string) error {
 	return createTables()
 }
 
+// IsOpen returns true when the database is open and the statements are prepared
+func IsOpen() bool {
+	return db != nil
+}
+
 func CloseDB() error {
 	if db == nil {
 		return os.ErrClosed
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ReadOnly is implemented by storages that do not accept changes. Writes to such storages fail with ErrReadOnly
+type ReadOnly interface {
+	ReadOnly() bool
+}
+
+// IsReadOnly returns true when s does not accept changes
+func IsReadOnly(s Storage) bool {
+	r, ok := s.(ReadOnly)
+	return ok && r.ReadOnly()
+}
+
+type archiveEntry struct {
+	simpleFileInfo
+	offset int64
+	zip    *zip.File
+}
+
+// Archive is a read-only storage on a zip or tar file, e.g. a snapshot of an exchange taken when a pool is
+// archived. Supported formats are .zip, .tar, .tar.gz and .tgz
+type Archive struct {
+	name    string
+	url     string
+	file    *os.File
+	zip     *zip.Reader
+	gzip    bool
+	entries map[string]archiveEntry
+}
+
+// OpenArchive opens a zip or tar file. The url is in the format archive://path/to/pool.zip for a relative path
+// and archive:///path/to/pool.zip for an absolute path
+func OpenArchive(connectionUrl string) (Storage, error) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(connectionUrl, "archive://"), "?")
+	f, err := os.Open(name)
+	if core.IsErr(err, "cannot open archive %s: %v", name) {
+		return nil, err
+	}
+
+	a := &Archive{name: name, url: Redact(connectionUrl), file: f, entries: map[string]archiveEntry{}}
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = a.indexZip()
+	case strings.HasSuffix(name, ".tar"):
+		err = a.indexTar()
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		a.gzip = true
+		err = a.indexTar()
+	default:
+		err = os.ErrInvalid
+	}
+	if core.IsErr(err, "cannot read archive %s: %v", name) {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// add adds an entry and its parent folders to the index
+func (a *Archive) add(name string, e archiveEntry) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return
+	}
+	e.name = path.Base(name)
+	if old, ok := a.entries[name]; ok && e.isDir && old.modTime.After(e.modTime) {
+		e.modTime = old.modTime
+	}
+	a.entries[name] = e
+
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		d := a.entries[dir]
+		d.name, d.isDir = path.Base(dir), true
+		if e.modTime.After(d.modTime) {
+			d.modTime = e.modTime
+		}
+		a.entries[dir] = d
+	}
+}
+
+func (a *Archive) indexZip() error {
+	stat, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	a.zip, err = zip.NewReader(a.file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range a.zip.File {
+		info := f.FileInfo()
+		a.add(f.Name, archiveEntry{
+			simpleFileInfo: simpleFileInfo{size: info.Size(), modTime: info.ModTime(), isDir: info.IsDir()},
+			zip:            f,
+		})
+	}
+	return nil
+}
+
+// countingReader tracks the offset of the entries in a tar file
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (a *Archive) tarReader(f *os.File) (*tar.Reader, *countingReader, error) {
+	var r io.Reader = f
+	if a.gzip {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = gr
+	}
+	cr := &countingReader{r: r}
+	return tar.NewReader(cr), cr, nil
+}
+
+func (a *Archive) indexTar() error {
+	tr, cr, err := a.tarReader(a.file)
+	if err != nil {
+		return err
+	}
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeDir {
+			continue
+		}
+		a.add(h.Name, archiveEntry{
+			simpleFileInfo: simpleFileInfo{size: h.Size, modTime: h.ModTime, isDir: h.Typeflag == tar.TypeDir},
+			offset:         cr.n,
+		})
+	}
+}
+
+func (a *Archive) entry(op, name string) (archiveEntry, error) {
+	k := strings.Trim(path.Clean("/"+name), "/")
+	if k == "" {
+		return archiveEntry{simpleFileInfo: simpleFileInfo{name: "/", isDir: true}}, nil
+	}
+	e, ok := a.entries[k]
+	if !ok {
+		return archiveEntry{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// open returns the content of an entry. Compressed tar files are scanned until the entry is found
+func (a *Archive) open(e archiveEntry) (io.ReadCloser, error) {
+	switch {
+	case e.zip != nil:
+		return e.zip.Open()
+	case !a.gzip:
+		return io.NopCloser(io.NewSectionReader(a.file, e.offset, e.size)), nil
+	}
+
+	f, err := os.Open(a.name)
+	if err != nil {
+		return nil, err
+	}
+	tr, cr, err := a.tarReader(f)
+	for err == nil {
+		if _, err = tr.Next(); err == nil && cr.n == e.offset {
+			return readCloser{tr, f}, nil
+		}
+	}
+	f.Close()
+	return nil, err
+}
+
+func (a *Archive) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	e, err := a.entry("read", name)
+	if err != nil {
+		return err
+	}
+	if e.isDir {
+		return &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	r, err := a.open(e)
+	if core.IsErr(err, "cannot open %s in %s: %v", name, a) {
+		return err
+	}
+	defer r.Close()
+
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
+
+	if rang == nil {
+		_, err = io.Copy(pw, r)
+	} else {
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(rang.From, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, r, rang.From)
+		}
+		if err == nil {
+			_, err = io.CopyN(pw, r, rang.To-rang.From)
+		}
+	}
+	if err != io.EOF && core.IsErr(err, "cannot read %s in %s: %v", name, a) {
+		return err
+	}
+	return nil
+}
+
+func (a *Archive) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	return &fs.PathError{Op: "write", Path: name, Err: ErrReadOnly}
+}
+
+func (a *Archive) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	d, err := a.entry("readdir", dir)
+	if err != nil {
+		return nil, err
+	}
+	if !d.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrInvalid}
+	}
+
+	prefix := strings.Trim(path.Clean("/"+dir), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var infos []fs.FileInfo
+	for k, e := range a.entries {
+		if !strings.HasPrefix(k, prefix) || strings.Contains(k[len(prefix):], "/") {
+			continue
+		}
+		if opts&IncludeHiddenFiles == 0 && isHidden(e.name) {
+			continue
+		}
+		infos = append(infos, e.simpleFileInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (a *Archive) Stat(name string) (os.FileInfo, error) {
+	e, err := a.entry("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.simpleFileInfo, nil
+}
+
+func (a *Archive) Rename(old, new string) error {
+	return &fs.PathError{Op: "rename", Path: old, Err: ErrReadOnly}
+}
+
+func (a *Archive) Delete(name string) error {
+	return &fs.PathError{Op: "delete", Path: name, Err: ErrReadOnly}
+}
+
+func (a *Archive) ReadOnly() bool {
+	return true
+}
+
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+func (a *Archive) String() string {
+	return a.url
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var archiveFiles = map[string]string{
+	"pool/feeds/20230101/1.head": "head",
+	"pool/feeds/20230101/1.body": "0123456789abcdefghij",
+	"pool/feeds/.touch":          "",
+	"pool/identities/abc":        "identity",
+}
+
+func writeZip(t *testing.T, name string) {
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for n, c := range archiveFiles {
+		fw, err := w.Create(n)
+		require.NoError(t, err)
+		fw.Write([]byte(c))
+	}
+	require.NoError(t, w.Close())
+}
+
+func writeTar(t *testing.T, name string, compress bool) {
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var out io.Writer = f
+	if compress {
+		gw := gzip.NewWriter(f)
+		defer gw.Close()
+		out = gw
+	}
+	w := tar.NewWriter(out)
+	for n, c := range archiveFiles {
+		require.NoError(t, w.WriteHeader(&tar.Header{Name: n, Mode: 0644, Size: int64(len(c)), ModTime: time.Now()}))
+		w.Write([]byte(c))
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "pool.zip"))
+	writeTar(t, filepath.Join(dir, "pool.tar"), false)
+	writeTar(t, filepath.Join(dir, "pool.tgz"), true)
+
+	for _, ext := range []string{"zip", "tar", "tgz"} {
+		t.Run(ext, func(t *testing.T) {
+			s, err := OpenStorage("archive://" + filepath.Join(dir, "pool."+ext) + "?retry=2")
+			require.NoError(t, err)
+			defer s.Close()
+			assert.True(t, IsReadOnly(s))
+
+			for n, c := range archiveFiles {
+				data, err := ReadFile(s, n)
+				assert.NoError(t, err)
+				assert.Equal(t, c, string(data))
+			}
+
+			var b bytes.Buffer
+			require.NoError(t, s.Read("pool/feeds/20230101/1.body", &Range{From: 5, To: 12}, &b, nil))
+			assert.Equal(t, "56789ab", b.String())
+
+			ls, err := s.ReadDir("pool/feeds", 0)
+			require.NoError(t, err)
+			require.Len(t, ls, 1)
+			assert.Equal(t, "20230101", ls[0].Name())
+			assert.True(t, ls[0].IsDir())
+
+			ls, err = s.ReadDir("pool/feeds", IncludeHiddenFiles)
+			require.NoError(t, err)
+			assert.Len(t, ls, 2)
+
+			stat, err := s.Stat("pool/feeds/20230101/1.body")
+			require.NoError(t, err)
+			assert.EqualValues(t, 20, stat.Size())
+
+			_, err = s.Stat("pool/missing")
+			assert.True(t, os.IsNotExist(err))
+			_, err = s.ReadDir("missing", 0)
+			assert.True(t, os.IsNotExist(err))
+
+			assert.True(t, errors.Is(WriteFile(s, "pool/new", []byte("x")), ErrReadOnly))
+			assert.True(t, errors.Is(s.Delete("pool/identities/abc"), ErrReadOnly))
+			assert.True(t, errors.Is(s.Rename("pool/identities/abc", "pool/x"), ErrReadOnly))
+		})
+	}
+}
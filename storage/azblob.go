@@ -0,0 +1,498 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/code-to-go/safepool/core"
+)
+
+type AzBlob struct {
+	client   *container.Client
+	url      string
+	partSize int64
+	parallel int
+}
+
+// azCopyPoll is the interval between two checks of a pending copy in Rename
+const azCopyPoll = 200 * time.Millisecond
+
+// OpenAzBlob creates a new Azure Blob Storage. The url is in the format azblob://account/container and the
+// credentials are either accountKey=... for shared key auth or sas=... for a SAS token with read, write,
+// list and delete permissions on the container. Optional parameters are endpoint for emulators such as
+// Azurite (e.g. endpoint=http://127.0.0.1:10000/devstoreaccount1), partSize in MB and parallel for block
+// uploads
+func OpenAzBlob(connectionUrl string) (Storage, error) {
+	u, err := url.Parse(connectionUrl)
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
+		return nil, err
+	}
+
+	q := u.Query()
+	account := u.Host
+	containerName := strings.Trim(u.Path, "/")
+	if account == "" || containerName == "" || strings.Contains(containerName, "/") {
+		core.IsErr(os.ErrInvalid, "invalid url '%s', expected azblob://account/container: %v", Redact(connectionUrl))
+		return nil, os.ErrInvalid
+	}
+
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+	containerUrl := strings.TrimRight(endpoint, "/") + "/" + containerName
+	repr := fmt.Sprintf("azblob://%s/%s", account, containerName)
+
+	var client *container.Client
+	switch {
+	case q.Get("accountKey") != "":
+		cred, err := container.NewSharedKeyCredential(account, q.Get("accountKey"))
+		if core.IsErr(err, "invalid account key for %s: %v", repr) {
+			return nil, err
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerUrl, cred, nil)
+		if core.IsErr(err, "cannot create Azure client for %s: %v", repr) {
+			return nil, err
+		}
+	case q.Get("sas") != "":
+		client, err = container.NewClientWithNoCredential(containerUrl+"?"+strings.TrimPrefix(q.Get("sas"), "?"), nil)
+		if core.IsErr(err, "cannot create Azure client for %s: %v", repr) {
+			return nil, err
+		}
+	default:
+		core.IsErr(os.ErrInvalid, "missing accountKey or sas in %s: %v", repr)
+		return nil, os.ErrInvalid
+	}
+
+	a := &AzBlob{
+		client:   client,
+		url:      repr,
+		partSize: DefaultPartSize,
+		parallel: DefaultParallelParts,
+	}
+	if v := q.Get("partSize"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 1 || mb > 4000 {
+			core.IsErr(os.ErrInvalid, "invalid partSize '%s' in %s: %v", v, repr)
+			return nil, os.ErrInvalid
+		}
+		a.partSize = int64(mb) * 1024 * 1024
+	}
+	if v := q.Get("parallel"); v != "" {
+		a.parallel, err = strconv.Atoi(v)
+		if err != nil || a.parallel < 1 {
+			core.IsErr(os.ErrInvalid, "invalid parallel '%s' in %s: %v", v, repr)
+			return nil, os.ErrInvalid
+		}
+	}
+
+	err = a.createContainerIfNeeded()
+	return a, err
+}
+
+// createContainerIfNeeded creates the container when the credentials allow it. A SAS token limited to the
+// container usually cannot create it, so a failure is reported only when the container is not accessible
+func (a *AzBlob) createContainerIfNeeded() error {
+	_, err := a.client.Create(context.TODO(), nil)
+	if err == nil || bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return nil
+	}
+
+	pager := a.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{MaxResults: to.Ptr(int32(1))})
+	_, lerr := pager.NextPage(context.TODO())
+	if core.IsErr(lerr, "cannot access container %s after create failed with %v: %v", a, err) {
+		return a.mapError(lerr)
+	}
+	return nil
+}
+
+func (a *AzBlob) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	var o blob.DownloadStreamOptions
+	if rang != nil {
+		o.Range = blob.HTTPRange{Offset: rang.From, Count: rang.To - rang.From}
+	}
+
+	res, err := a.client.NewBlobClient(name).DownloadStream(context.TODO(), &o)
+	if err != nil {
+		err = a.mapError(err)
+		if os.IsNotExist(err) || core.IsErr(err, "cannot read %s/%s: %v", a, name) {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
+
+	_, err = io.Copy(pw, res.Body)
+	if core.IsErr(err, "cannot read %s/%s: %v", a, name) {
+		return err
+	}
+	return nil
+}
+
+func (a *AzBlob) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	if size > a.partSize {
+		return a.writeBlocks(name, source, size, progress)
+	}
+
+	pr := newProgressReader(source, progress)
+	defer pr.flush()
+
+	_, err := a.client.NewBlockBlobClient(name).Upload(context.TODO(), streaming.NopCloser(pr), nil)
+	core.IsErr(err, "cannot write %s/%s: %v", a, name)
+	return a.mapError(err)
+}
+
+// blockId returns the id of the block at index in a block upload. The id includes the MD5 of the block, so
+// that blocks staged by a previous failed upload of the same content are recognized and skipped. Azure
+// requires the ids of a blob to have the same length
+func blockId(index int64, sum [md5.Size]byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%06d-%x", index, sum)))
+}
+
+// writeBlocks stages source in blocks of partSize bytes, with up to parallel blocks in flight, and commits
+// the block list. Uncommitted blocks are kept by Azure for a week, so a new write of the same content after
+// a failure stages only the missing blocks
+func (a *AzBlob) writeBlocks(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	bb := a.client.NewBlockBlobClient(name)
+
+	staged := map[string]bool{}
+	res, err := bb.GetBlockList(context.TODO(), blockblob.BlockListTypeUncommitted, nil)
+	if err == nil && res.BlockList.UncommittedBlocks != nil {
+		for _, b := range res.BlockList.UncommittedBlocks {
+			if b.Name != nil {
+				staged[*b.Name] = true
+			}
+		}
+		core.Debug("found %d staged blocks for %s/%s", len(staged), a, name)
+	}
+
+	count := (size + a.partSize - 1) / a.partSize
+	ids := make([]string, count)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mutex.Unlock()
+	}
+
+	slots := make(chan bool, a.parallel)
+	for i := int64(0); i < count && !failed(); i++ {
+		slots <- true
+
+		buf := make([]byte, core.If(i == count-1, size-i*a.partSize, a.partSize))
+		if _, err := io.ReadFull(source, buf); core.IsErr(err, "cannot read block %d of %s: %v", i, name) {
+			<-slots
+			fail(err)
+			break
+		}
+
+		ids[i] = blockId(i, md5.Sum(buf))
+		if staged[ids[i]] {
+			core.Debug("block %d of %s/%s already staged", i, a, name)
+			if progress != nil {
+				progress <- int64(len(buf))
+			}
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int64, buf []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			_, err := bb.StageBlock(context.TODO(), ids[i], streaming.NopCloser(bytes.NewReader(buf)), nil)
+			if core.IsErr(err, "cannot stage block %d of %s/%s: %v", i, a, name) {
+				fail(a.mapError(err))
+				return
+			}
+			if progress != nil {
+				progress <- int64(len(buf))
+			}
+		}(i, buf)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	_, err = bb.CommitBlockList(context.TODO(), ids, nil)
+	core.IsErr(err, "cannot commit blocks of %s/%s: %v", a, name)
+	return a.mapError(err)
+}
+
+func (a *AzBlob) ReadWithETag(name string, dest io.Writer) (string, error) {
+	res, err := a.client.NewBlobClient(name).DownloadStream(context.TODO(), nil)
+	if err != nil {
+		err = a.mapError(err)
+		if os.IsNotExist(err) || core.IsErr(err, "cannot read %s/%s: %v", a, name) {
+			return "", err
+		}
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(dest, res.Body)
+	if core.IsErr(err, "cannot read %s/%s: %v", a, name) {
+		return "", err
+	}
+	return string(azValue(res.ETag)), nil
+}
+
+// WriteIf maps the condition to the If-None-Match and If-Match access conditions of Azure
+func (a *AzBlob) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	var mac blob.ModifiedAccessConditions
+	if cond.IfNoneMatch {
+		mac.IfNoneMatch = to.Ptr(azcore.ETagAny)
+	}
+	if cond.IfMatch != "" {
+		mac.IfMatch = to.Ptr(azcore.ETag(cond.IfMatch))
+	}
+
+	res, err := a.client.NewBlockBlobClient(name).Upload(context.TODO(), streaming.NopCloser(source),
+		&blockblob.UploadOptions{AccessConditions: &blob.AccessConditions{ModifiedAccessConditions: &mac}})
+	if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) ||
+		cond.IfMatch != "" && bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return "", &fs.PathError{Op: "write", Path: name, Err: ErrPreconditionFailed}
+	}
+	if core.IsErr(err, "cannot write %s/%s: %v", a, name) {
+		return "", a.mapError(err)
+	}
+	return string(azValue(res.ETag)), nil
+}
+
+// Presign returns the url of the blob with a read-only SAS. A SAS can be signed only with the account key, so
+// the storage must be opened with accountKey
+func (a *AzBlob) Presign(name string, expiry time.Duration) (string, error) {
+	u, err := a.client.NewBlobClient(name).GetSASURL(sas.BlobPermissions{Read: true}, core.Now().Add(expiry), nil)
+	if errors.Is(err, bloberror.MissingSharedKeyCredential) {
+		return "", ErrNotSupported
+	}
+	if core.IsErr(err, "cannot presign %s/%s: %v", a, name) {
+		return "", a.mapError(err)
+	}
+	return u, nil
+}
+
+func (a *AzBlob) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	prefix := strings.Trim(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var infos []fs.FileInfo
+	var found bool
+	pager := a.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pager.More() {
+		res, err := pager.NextPage(context.TODO())
+		if core.IsErr(err, "cannot list %s/%s: %v", a, dir) {
+			return nil, a.mapError(err)
+		}
+
+		for _, item := range res.Segment.BlobPrefixes {
+			found = true
+			name := strings.TrimRight((*item.Name)[len(prefix):], "/")
+			if opts&IncludeHiddenFiles == 0 && isHidden(name) {
+				continue
+			}
+			infos = append(infos, simpleFileInfo{name: name, isDir: true})
+		}
+		for _, item := range res.Segment.BlobItems {
+			found = true
+			name := (*item.Name)[len(prefix):]
+			if name == "" || opts&IncludeHiddenFiles == 0 && isHidden(name) {
+				continue
+			}
+			infos = append(infos, azFileInfo(name, item.Properties.ContentLength, item.Properties.LastModified))
+		}
+	}
+	if !found && prefix != "" {
+		return nil, fs.ErrNotExist
+	}
+	return infos, nil
+}
+
+// List returns a page of the entries in dir. Azure does not support StartAfter in listings, so the entries
+// before StartAfter are skipped on the client
+func (a *AzBlob) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	prefix := strings.Trim(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	o := container.ListBlobsHierarchyOptions{
+		Prefix: to.Ptr(prefix + filter.Prefix),
+	}
+	if filter.Limit > 0 {
+		o.MaxResults = to.Ptr(int32(filter.Limit))
+	}
+	if token != "" {
+		o.Marker = to.Ptr(token)
+	}
+
+	res, err := a.client.NewListBlobsHierarchyPager("/", &o).NextPage(context.TODO())
+	if core.IsErr(err, "cannot list %s/%s: %v", a, dir) {
+		return nil, "", a.mapError(err)
+	}
+
+	var infos []fs.FileInfo
+	for _, item := range res.Segment.BlobPrefixes {
+		name := strings.TrimRight((*item.Name)[len(prefix):], "/")
+		if filter.match(name) {
+			infos = append(infos, simpleFileInfo{name: name, isDir: true})
+		}
+	}
+	for _, item := range res.Segment.BlobItems {
+		name := (*item.Name)[len(prefix):]
+		if name != "" && filter.match(name) {
+			infos = append(infos, azFileInfo(name, item.Properties.ContentLength, item.Properties.LastModified))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	if token == "" && filter.Prefix == "" && filter.StartAfter == "" && prefix != "" &&
+		len(res.Segment.BlobPrefixes) == 0 && len(res.Segment.BlobItems) == 0 {
+		return nil, "", fs.ErrNotExist
+	}
+	return infos, azValue(res.NextMarker), nil
+}
+
+func azFileInfo(name string, size *int64, modTime *time.Time) simpleFileInfo {
+	return simpleFileInfo{
+		name:    name,
+		size:    azValue(size),
+		modTime: azValue(modTime),
+	}
+}
+
+// azValue returns the value of an optional field in an Azure response or the zero value when missing
+func azValue[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
+
+func (a *AzBlob) mapError(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound, bloberror.ResourceNotFound) {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+func (a *AzBlob) Stat(name string) (fs.FileInfo, error) {
+	res, err := a.client.NewBlobClient(name).GetProperties(context.TODO(), nil)
+	if err != nil {
+		// HEAD responses have no body, so the error code is not available
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == 404 {
+			return nil, fs.ErrNotExist
+		}
+		return nil, a.mapError(err)
+	}
+
+	fi := azFileInfo(path.Base(name), res.ContentLength, res.LastModified)
+	fi.isDir = strings.HasSuffix(name, "/")
+	return fi, nil
+}
+
+// Rename copies the blob to the new name and deletes the old one. Copies within the same account are
+// usually synchronous; a pending copy is polled until it completes
+func (a *AzBlob) Rename(old, new string) error {
+	src := a.client.NewBlobClient(old)
+	dst := a.client.NewBlobClient(new)
+
+	res, err := dst.StartCopyFromURL(context.TODO(), src.URL(), nil)
+	if err != nil {
+		return a.mapError(err)
+	}
+	for status := azValue(res.CopyStatus); status == blob.CopyStatusTypePending; {
+		time.Sleep(azCopyPoll)
+		props, err := dst.GetProperties(context.TODO(), nil)
+		if core.IsErr(err, "cannot check copy of %s to %s in %s: %v", old, new, a) {
+			return a.mapError(err)
+		}
+		status = azValue(props.CopyStatus)
+		if status != blob.CopyStatusTypePending && status != blob.CopyStatusTypeSuccess {
+			err = fmt.Errorf("copy of %s to %s ended with status %s: %s", old, new, status,
+				azValue(props.CopyStatusDescription))
+			core.IsErr(err, "cannot rename in %s: %v", a)
+			return err
+		}
+	}
+
+	_, err = src.Delete(context.TODO(), nil)
+	return a.mapError(err)
+}
+
+// Delete removes the blob with the provided name and all the blobs in the folder with the same name
+func (a *AzBlob) Delete(name string) error {
+	pager := a.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: to.Ptr(name + "/"),
+	})
+	for pager.More() {
+		res, err := pager.NextPage(context.TODO())
+		if core.IsErr(err, "cannot list %s for delete: %v", name) {
+			return a.mapError(err)
+		}
+		for _, item := range res.Segment.BlobItems {
+			_, err = a.client.NewBlobClient(*item.Name).Delete(context.TODO(), nil)
+			if bloberror.HasCode(err, bloberror.BlobNotFound) {
+				continue
+			}
+			if core.IsErr(err, "cannot delete %s: %v", *item.Name) {
+				return a.mapError(err)
+			}
+		}
+	}
+
+	_, err := a.client.NewBlobClient(name).Delete(context.TODO(), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	core.IsErr(err, "cannot delete %s: %v", name)
+	return a.mapError(err)
+}
+
+func (a *AzBlob) Close() error {
+	return nil
+}
+
+func (a *AzBlob) String() string {
+	return a.url
+}
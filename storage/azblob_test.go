@@ -0,0 +1,53 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/code-to-go/safepool/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzBlobUrl(t *testing.T) {
+	for _, u := range []string{
+		"azblob://account",
+		"azblob://account/container/folder?accountKey=abc",
+		"azblob://account/container",
+		"azblob://account/container?accountKey=YWJj&partSize=0",
+	} {
+		_, err := storage.OpenStorage(u)
+		assert.ErrorIs(t, err, os.ErrInvalid, u)
+	}
+	assert.Equal(t, "azblob://account/container?accountKey=%2A%2A%2A",
+		storage.Redact("azblob://account/container?accountKey=abc"))
+}
+
+func TestAzBlobBlocks(t *testing.T) {
+	s, err := storage.OpenStorage(startAzurite(t) + "&partSize=1&parallel=2")
+	require.NoError(t, err)
+	defer s.Close()
+
+	data := make([]byte, 3*1024*1024+100)
+	rand.Read(data)
+	size := int64(len(data))
+
+	// the source breaks after the first block, as when the app is killed during the upload
+	broken := readSeeker{io.MultiReader(bytes.NewReader(data[0:1024*1024]), iotest.ErrReader(io.ErrUnexpectedEOF)), nil}
+	assert.Error(t, s.Write("big.bin", broken, size, nil))
+	_, err = s.Stat("big.bin")
+	assert.Error(t, err)
+
+	require.NoError(t, s.Write("big.bin", bytes.NewReader(data), size, nil))
+	got, err := storage.ReadFile(s, "big.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	var b bytes.Buffer
+	require.NoError(t, s.Read("big.bin", &storage.Range{From: 1024*1024 - 10, To: 1024*1024 + 10}, &b, nil))
+	assert.Equal(t, data[1024*1024-10:1024*1024+10], b.Bytes())
+}
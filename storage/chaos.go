@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// ChaosConfig defines the faults injected by a Chaos storage. Rates are probabilities between 0 and 1
+type ChaosConfig struct {
+	Seed              int64         // seed for the random generator; the same seed replays the same faults
+	ErrorRate         float64       // an operation fails without any effect
+	LatencyRate       float64       // an operation is delayed up to MaxLatency
+	MaxLatency        time.Duration // upper limit of an injected delay
+	PartialWriteRate  float64       // a write stores only the first half of the content and fails
+	DroppedWriteRate  float64       // a write reports success but nothing is stored
+	TruncatedReadRate float64       // a read returns only the first half of the content and fails
+	StaleListRate     float64       // a written file is not listed by ReadDir for ListDelay
+	ListDelay         time.Duration // time a file stays hidden from listings
+}
+
+// ChaosError is returned for injected faults. It is a timeout so that it is considered transient
+type ChaosError struct {
+	Op   string
+	Name string
+}
+
+func (e *ChaosError) Error() string {
+	return fmt.Sprintf("chaos: injected fault on %s %s", e.Op, e.Name)
+}
+
+func (e *ChaosError) Timeout() bool {
+	return true
+}
+
+func (e *ChaosError) Temporary() bool {
+	return true
+}
+
+// Chaos wraps a storage and injects faults for resilience testing. Runs are reproducible when operations
+// are called in the same order with the same seed
+type Chaos struct {
+	s      Storage
+	config ChaosConfig
+	rand   *rand.Rand
+	hidden map[string]time.Time
+	mutex  sync.Mutex
+}
+
+// NewChaos wraps s with the faults defined in config
+func NewChaos(s Storage, config ChaosConfig) Storage {
+	return &Chaos{
+		s:      s,
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		hidden: map[string]time.Time{},
+	}
+}
+
+// chaosFromUrl wraps s when the connection url contains the chaos parameter with the seed. Rates and
+// durations are set with chaosError, chaosLatency, chaosMaxLatency, chaosPartialWrite, chaosDroppedWrite,
+// chaosTruncatedRead, chaosStaleList and chaosListDelay (e.g. mem://test?chaos=1&chaosError=0.1)
+func chaosFromUrl(s Storage, query map[string][]string) (Storage, error) {
+	if queryValue(query, "chaos") == "" {
+		return s, nil
+	}
+	return wrapChaos(s, query)
+}
+
+// wrapChaos wraps s with the chaos parameters of the url. The seed is 0 when the chaos parameter is missing
+func wrapChaos(s Storage, query map[string][]string) (Storage, error) {
+	var c ChaosConfig
+	var err error
+	if seed := queryValue(query, "chaos"); seed != "" {
+		c.Seed, err = strconv.ParseInt(seed, 10, 64)
+		if core.IsErr(err, "invalid chaos seed '%s': %v", seed) {
+			return nil, err
+		}
+	}
+
+	rates := map[string]*float64{
+		"chaosError":         &c.ErrorRate,
+		"chaosLatency":       &c.LatencyRate,
+		"chaosPartialWrite":  &c.PartialWriteRate,
+		"chaosDroppedWrite":  &c.DroppedWriteRate,
+		"chaosTruncatedRead": &c.TruncatedReadRate,
+		"chaosStaleList":     &c.StaleListRate,
+	}
+	for k, r := range rates {
+		if v := queryValue(query, k); v != "" {
+			*r, err = strconv.ParseFloat(v, 64)
+			if core.IsErr(err, "invalid %s parameter '%s': %v", k, v) {
+				return nil, err
+			}
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"chaosMaxLatency": &c.MaxLatency,
+		"chaosListDelay":  &c.ListDelay,
+	}
+	for k, d := range durations {
+		if v := queryValue(query, k); v != "" {
+			*d, err = time.ParseDuration(v)
+			if core.IsErr(err, "invalid %s parameter '%s': %v", k, v) {
+				return nil, err
+			}
+		}
+	}
+	return NewChaos(s, c), nil
+}
+
+// SetConfig changes the faults injected by the next operations, e.g. to simulate an exchange that is back
+// online. The random generator is not reset
+func (c *Chaos) SetConfig(config ChaosConfig) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.config = config
+}
+
+func (c *Chaos) getConfig() ChaosConfig {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.config
+}
+
+func (c *Chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rand.Float64() < rate
+}
+
+// inject delays the operation and returns an error according to the configured rates
+func (c *Chaos) inject(op, name string) error {
+	config := c.getConfig()
+	if c.roll(config.LatencyRate) && config.MaxLatency > 0 {
+		c.mutex.Lock()
+		d := time.Duration(c.rand.Int63n(int64(config.MaxLatency)))
+		c.mutex.Unlock()
+		time.Sleep(d)
+	}
+	if c.roll(config.ErrorRate) {
+		core.Debug("chaos: fail %s on %s", op, name)
+		return &ChaosError{op, name}
+	}
+	return nil
+}
+
+func (c *Chaos) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	if err := c.inject("read", name); err != nil {
+		return err
+	}
+	if !c.roll(c.getConfig().TruncatedReadRate) {
+		return c.s.Read(name, rang, dest, progress)
+	}
+
+	var b bytes.Buffer
+	err := c.s.Read(name, rang, &b, progress)
+	if err != nil {
+		return err
+	}
+	core.Debug("chaos: truncate read of %s", name)
+	dest.Write(b.Bytes()[0 : b.Len()/2])
+	return &ChaosError{"read", name}
+}
+
+func (c *Chaos) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	if err := c.inject("write", name); err != nil {
+		return err
+	}
+	if c.roll(c.getConfig().DroppedWriteRate) {
+		core.Debug("chaos: drop write of %s", name)
+		_, err := io.Copy(io.Discard, source)
+		return err
+	}
+	if c.roll(c.getConfig().PartialWriteRate) {
+		core.Debug("chaos: partial write of %s", name)
+		half := make([]byte, size/2)
+		n, _ := io.ReadFull(source, half)
+		c.s.Write(name, core.NewBytesReader(half[0:n]), int64(n), progress)
+		return &ChaosError{"write", name}
+	}
+
+	err := c.s.Write(name, source, size, progress)
+	if config := c.getConfig(); err == nil && c.roll(config.StaleListRate) {
+		c.mutex.Lock()
+		c.hidden[path.Clean(name)] = time.Now().Add(config.ListDelay)
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+// ReadDir hides recently written files to simulate eventual consistency
+func (c *Chaos) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	if err := c.inject("readdir", dir); err != nil {
+		return nil, err
+	}
+	infos, err := c.s.ReadDir(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.visible(dir, infos), nil
+}
+
+// List hides recently written files like ReadDir
+func (c *Chaos) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	if err := c.inject("readdir", dir); err != nil {
+		return nil, "", err
+	}
+	infos, next, err := ListPage(c.s, dir, filter, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return c.visible(dir, infos), next, nil
+}
+
+// visible removes from infos the files whose listing is delayed
+func (c *Chaos) visible(dir string, infos []fs.FileInfo) []fs.FileInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	var visible []fs.FileInfo
+	for _, info := range infos {
+		n := path.Join(dir, info.Name())
+		if until, ok := c.hidden[n]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(c.hidden, n)
+		}
+		visible = append(visible, info)
+	}
+	return visible
+}
+
+func (c *Chaos) Stat(name string) (os.FileInfo, error) {
+	if err := c.inject("stat", name); err != nil {
+		return nil, err
+	}
+	return c.s.Stat(name)
+}
+
+func (c *Chaos) Rename(old, new string) error {
+	if err := c.inject("rename", old); err != nil {
+		return err
+	}
+	return c.s.Rename(old, new)
+}
+
+func (c *Chaos) Delete(name string) error {
+	if err := c.inject("delete", name); err != nil {
+		return err
+	}
+	return c.s.Delete(name)
+}
+
+// ReadWithETag injects the same failures as Read
+func (c *Chaos) ReadWithETag(name string, dest io.Writer) (string, error) {
+	if err := c.inject("read", name); err != nil {
+		return "", err
+	}
+	return ReadWithETag(c.s, name, dest)
+}
+
+// WriteIf injects failures before the write. Dropped and partial writes are not simulated since a
+// conditional write is applied completely or not at all
+func (c *Chaos) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	if err := c.inject("write", name); err != nil {
+		return "", err
+	}
+	return WriteIf(c.s, name, source, size, cond)
+}
+
+func (c *Chaos) Watch(dir string, stop chan struct{}) (chan string, error) {
+	return watchInner(c.s, dir, stop)
+}
+
+func (c *Chaos) Presign(name string, expiry time.Duration) (string, error) {
+	return Presign(c.s, name, expiry)
+}
+
+func (c *Chaos) ReadOnly() bool {
+	return IsReadOnly(c.s)
+}
+
+func (c *Chaos) Close() error {
+	return c.s.Close()
+}
+
+func (c *Chaos) String() string {
+	return c.s.String()
+}
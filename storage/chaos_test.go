@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func chaosFaults(seed int64) []bool {
+	m, _ := OpenStorage("mem://" + uuid.New().String())
+	c := NewChaos(m, ChaosConfig{Seed: seed, ErrorRate: 0.5})
+
+	var faults []bool
+	for i := 0; i < 32; i++ {
+		faults = append(faults, WriteFile(c, "a.txt", nil) != nil)
+	}
+	return faults
+}
+
+func TestChaosSeed(t *testing.T) {
+	assert.Equal(t, chaosFaults(42), chaosFaults(42))
+	assert.NotEqual(t, chaosFaults(42), chaosFaults(43))
+}
+
+func TestChaosFaults(t *testing.T) {
+	m, _ := OpenStorage("mem://" + uuid.New().String())
+
+	c := NewChaos(m, ChaosConfig{DroppedWriteRate: 1})
+	assert.NoError(t, WriteFile(c, "dropped.txt", []byte("data")))
+	_, err := m.Stat("dropped.txt")
+	assert.Error(t, err)
+
+	c = NewChaos(m, ChaosConfig{PartialWriteRate: 1})
+	err = WriteFile(c, "partial.txt", []byte("0123456789"))
+	var chaosErr *ChaosError
+	assert.True(t, errors.As(err, &chaosErr))
+	assert.True(t, IsRetryable(err))
+	data, _ := ReadFile(m, "partial.txt")
+	assert.Equal(t, []byte("01234"), data)
+
+	c = NewChaos(m, ChaosConfig{StaleListRate: 1, ListDelay: 50 * time.Millisecond})
+	assert.NoError(t, WriteFile(c, "dir/a.txt", nil))
+	ls, err := c.ReadDir("dir", 0)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 0)
+	time.Sleep(60 * time.Millisecond)
+	ls, _ = c.ReadDir("dir", 0)
+	assert.Len(t, ls, 1)
+}
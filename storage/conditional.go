@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var ErrPreconditionFailed = errors.New("the condition of the write does not hold")
+var ErrNotSupported = errors.New("operation not supported by the storage")
+
+// Condition is the requirement for a conditional write. With IfNoneMatch the file must not exist, with IfMatch
+// the file must have the provided ETag
+type Condition struct {
+	IfNoneMatch bool
+	IfMatch     string
+}
+
+// ConditionalWriter is implemented by storages that support compare-and-swap writes. An ETag is an opaque
+// tag that changes every time the content of a file changes
+type ConditionalWriter interface {
+	// ReadWithETag reads the content of a file and returns its ETag
+	ReadWithETag(name string, dest io.Writer) (string, error)
+
+	// WriteIf writes a file only when cond holds and returns the ETag of the new content. It returns
+	// ErrPreconditionFailed when cond does not hold
+	WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error)
+}
+
+// ReadWithETag reads the content and the ETag of a file. It returns ErrNotSupported when s is not a
+// ConditionalWriter
+func ReadWithETag(s Storage, name string, dest io.Writer) (string, error) {
+	c, ok := s.(ConditionalWriter)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	return c.ReadWithETag(name, dest)
+}
+
+// WriteIf writes a file when cond holds. It returns ErrNotSupported when s is not a ConditionalWriter
+func WriteIf(s Storage, name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	c, ok := s.(ConditionalWriter)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	return c.WriteIf(name, source, size, cond)
+}
+
+// contentTag is the ETag of storages that do not provide one, computed from the content
+func contentTag(data []byte) string {
+	h := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
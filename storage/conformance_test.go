@@ -0,0 +1,54 @@
+package storage_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/code-to-go/safepool/storage"
+	"github.com/code-to-go/safepool/storage/storagetest"
+	"github.com/google/uuid"
+)
+
+func openFunc(url string) storagetest.OpenFunc {
+	return func() (storage.Storage, error) {
+		return storage.OpenStorage(url)
+	}
+}
+
+func TestLocalConformance(t *testing.T) {
+	storagetest.Run(t, openFunc("file://"+t.TempDir()))
+}
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, openFunc("mem://"+uuid.New().String()))
+}
+
+func TestSFTPConformance(t *testing.T) {
+	connectionUrl, fingerprint := startSFTP(t)
+	storagetest.Run(t, openFunc(connectionUrl+"?hostkey="+url.QueryEscape(fingerprint)))
+}
+
+func TestWebDAVConformance(t *testing.T) {
+	storagetest.Run(t, openFunc(startWebDAV(t)))
+}
+
+func TestS3Conformance(t *testing.T) {
+	storagetest.Run(t, openFunc(startS3(t)))
+}
+
+func TestAzBlobConformance(t *testing.T) {
+	storagetest.Run(t, openFunc(startAzurite(t)))
+}
+
+func TestRetryConformance(t *testing.T) {
+	storagetest.Run(t, openFunc("mem://"+uuid.New().String()+"?retry=3"))
+}
+
+func TestMetaCacheConformance(t *testing.T) {
+	storagetest.Run(t, openFunc("mem://"+uuid.New().String()+"?cache=1m"))
+}
+
+func TestChaosConformance(t *testing.T) {
+	storagetest.Run(t, openFunc("mem://"+uuid.New().String()+
+		"?chaos=1&chaosError=0.1&chaosPartialWrite=0.1&chaosTruncatedRead=0.1&retry=10"))
+}
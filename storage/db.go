@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/sql"
+)
+
+// Upload state is kept only when the local database is open, so that the storage package can be used without it
+
+func sqlGetUpload(storage, name string) (uploadId string, size int64, partSize int64, ok bool) {
+	if !sql.IsOpen() {
+		return "", 0, 0, false
+	}
+	err := sql.QueryRow("GET_UPLOAD", sql.Args{"storage": storage, "name": name}, &uploadId, &size, &partSize)
+	if err == sql.ErrNoRows || core.IsErr(err, "cannot get upload state for %s/%s: %v", storage, name) {
+		return "", 0, 0, false
+	}
+	return uploadId, size, partSize, true
+}
+
+func sqlSetUpload(storage, name string, uploadId string, size int64, partSize int64) error {
+	if !sql.IsOpen() {
+		return nil
+	}
+	_, err := sql.Exec("SET_UPLOAD", sql.Args{"storage": storage, "name": name, "uploadId": uploadId,
+		"size": size, "partSize": partSize, "ctime": core.Now().Unix()})
+	core.IsErr(err, "cannot save upload state for %s/%s: %v", storage, name)
+	return err
+}
+
+func sqlDelUpload(storage, name string) error {
+	if !sql.IsOpen() {
+		return nil
+	}
+	_, err := sql.Exec("DEL_UPLOAD", sql.Args{"storage": storage, "name": name})
+	core.IsErr(err, "cannot delete upload state for %s/%s: %v", storage, name)
+	return err
+}
@@ -31,7 +31,7 @@ func NewFirebase(connectionUrl string) (Storage, error) {
 	if base == "" {
 		base = "/"
 	}
-	return &Local{base, connectionUrl, map[string]time.Time{}}, nil
+	return &Local{base, Redact(connectionUrl), map[string]time.Time{}}, nil
 }
 
 func (l *Firebase) GetCheckpoint(name string) int64 {
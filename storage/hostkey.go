@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/sql"
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrHostKeyMismatch = errors.New("the host key of the server does not match the pinned key")
+
+// hostKeysNode is the configs node where the keys of SFTP servers are pinned
+const hostKeysNode = "sftp/hostkeys"
+
+// HostKey is the key of an SFTP server pinned on the first connection
+type HostKey struct {
+	Addr        string    `json:"addr"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	Pinned      time.Time `json:"pinned"`
+}
+
+// HostKeys returns the pinned keys of SFTP servers
+func HostKeys() ([]HostKey, error) {
+	if !sql.IsOpen() {
+		return nil, core.ErrNotInitialized
+	}
+	addrs, err := sql.GetConfigKeys(hostKeysNode)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []HostKey
+	for _, addr := range addrs {
+		fingerprint, pinned, data, _ := sql.GetConfig(hostKeysNode, addr)
+		k := HostKey{Addr: addr, Fingerprint: fingerprint, Pinned: time.Unix(pinned, 0)}
+		if key, err := ssh.ParsePublicKey(data); err == nil {
+			k.Type = key.Type()
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ResetHostKey removes the pinned key for addr (e.g. sftp.example.com:22), so that the key presented on the
+// next connection is trusted. It is meant for servers whose key changed legitimately
+func ResetHostKey(addr string) error {
+	if !sql.IsOpen() {
+		return core.ErrNotInitialized
+	}
+	return sql.DelConfig(hostKeysNode, addr)
+}
+
+// parseFingerprint normalizes a hostkey parameter to the SHA256:... format used by ssh-keygen -l. A + not
+// escaped in the url is decoded as a space and is restored
+func parseFingerprint(v string) string {
+	v = strings.ReplaceAll(v, " ", "+")
+	if v != "" && !strings.HasPrefix(v, "SHA256:") {
+		v = "SHA256:" + v
+	}
+	return strings.TrimRight(v, "=")
+}
+
+// hostKeyCallback verifies the key of the server against expected when not empty. Otherwise the key is pinned
+// in the local database on the first connection and verified on the next ones; without a database the
+// connection is refused. A verification error is also saved in failure, since the ssh package does not wrap it
+func hostKeyCallback(expected string, failure *error) ssh.HostKeyCallback {
+	return func(addr string, remote net.Addr, key ssh.PublicKey) error {
+		*failure = verifyHostKey(expected, addr, key)
+		return *failure
+	}
+}
+
+func verifyHostKey(expected string, addr string, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	if expected != "" {
+		if fingerprint != expected {
+			return fmt.Errorf("%w: %s presented %s while the hostkey parameter is %s", ErrHostKeyMismatch,
+				addr, fingerprint, expected)
+		}
+		return nil
+	}
+
+	if !sql.IsOpen() {
+		return fmt.Errorf("%w: no database for pinning the key %s of %s; set the hostkey parameter in the url",
+			core.ErrNotInitialized, fingerprint, addr)
+	}
+
+	pinned, _, _, ok := sql.GetConfig(hostKeysNode, addr)
+	if !ok {
+		core.Info("pin key %s for %s on first connection", fingerprint, addr)
+		return sql.SetConfig(hostKeysNode, addr, fingerprint, core.Now().Unix(), key.Marshal())
+	}
+	if pinned != fingerprint {
+		return fmt.Errorf("%w: %s presented %s while %s is pinned; reset the pinned key only if the server "+
+			"key changed legitimately", ErrHostKeyMismatch, addr, fingerprint, pinned)
+	}
+	return nil
+}
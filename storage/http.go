@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// IndexFile is the name of the file that lists the content of a folder on an HTTP mirror. It is hidden, so
+// it is not listed by ReadDir
+const IndexFile = ".index.json"
+
+// indexEntry is an item in an index file
+type indexEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir,omitempty"`
+}
+
+// HTTP is a read-only storage on a static web server or a CDN, e.g. a public pool published with the content
+// of an exchange. Files are read with GET requests and folders are listed with the index files created by
+// WriteIndex
+type HTTP struct {
+	c    *http.Client
+	base *url.URL
+	url  string
+}
+
+// OpenHTTP opens a mirror at an http:// or https:// url. Credentials in the url are sent with basic
+// authentication
+func OpenHTTP(connectionUrl string) (Storage, error) {
+	u, err := url.Parse(connectionUrl)
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		core.IsErr(os.ErrInvalid, "invalid scheme %s: %v", u.Scheme)
+		return nil, os.ErrInvalid
+	}
+	u.RawQuery, u.Fragment = "", ""
+
+	return &HTTP{
+		c:    &http.Client{},
+		base: u,
+		url:  Redact(connectionUrl),
+	}, nil
+}
+
+// get sends a GET request for name. A missing file is reported as fs.ErrNotExist
+func (h *HTTP) get(op, name string, rang *Range) (*http.Response, error) {
+	u := *h.base
+	u.User = nil
+	u.Path = path.Join("/", h.base.Path, name)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if password, ok := h.base.User.Password(); ok {
+		req.SetBasicAuth(h.base.User.Username(), password)
+	}
+	if rang != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rang.From, rang.To-1))
+	}
+
+	resp, err := h.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s for %s", resp.Status, name)
+	}
+}
+
+func (h *HTTP) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	resp, err := h.get("read", name, rang)
+	if os.IsNotExist(err) || core.IsErr(err, "cannot read %s from %s: %v", name, h) {
+		return err
+	}
+	defer resp.Body.Close()
+
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
+
+	if rang == nil {
+		_, err = io.Copy(pw, resp.Body)
+	} else {
+		// servers without range support return the whole file
+		if resp.StatusCode == http.StatusOK {
+			_, err = io.CopyN(io.Discard, resp.Body, rang.From)
+		}
+		if err == nil {
+			_, err = io.CopyN(pw, resp.Body, rang.To-rang.From)
+		}
+	}
+	if err != io.EOF && core.IsErr(err, "cannot read from GET response on %s: %v", name) {
+		return err
+	}
+	return nil
+}
+
+func (h *HTTP) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	return &fs.PathError{Op: "write", Path: name, Err: ErrReadOnly}
+}
+
+// readIndex returns the entries in the index file of a folder
+func (h *HTTP) readIndex(op, dir string) ([]indexEntry, error) {
+	resp, err := h.get(op, path.Join(dir, IndexFile), nil)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = &fs.PathError{Op: op, Path: dir, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []indexEntry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	if core.IsErr(err, "invalid index for %s in %s: %v", dir, h) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (h *HTTP) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	entries, err := h.readIndex("readdir", dir)
+	if os.IsNotExist(err) || core.IsErr(err, "cannot read folder %s in %s: %v", dir, h) {
+		return nil, err
+	}
+
+	var infos []fs.FileInfo
+	for _, e := range entries {
+		if opts&IncludeHiddenFiles != 0 || !isHidden(e.Name) {
+			infos = append(infos, simpleFileInfo{name: e.Name, size: e.Size, modTime: e.ModTime, isDir: e.IsDir})
+		}
+	}
+	return infos, nil
+}
+
+func (h *HTTP) Stat(name string) (os.FileInfo, error) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return simpleFileInfo{name: "/", isDir: true}, nil
+	}
+
+	dir, base := path.Split(name)
+	entries, err := h.readIndex("stat", dir)
+	if os.IsNotExist(err) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	if core.IsErr(err, "cannot stat %s in %s: %v", name, h) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name == base {
+			return simpleFileInfo{name: e.Name, size: e.Size, modTime: e.ModTime, isDir: e.IsDir}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (h *HTTP) Rename(old, new string) error {
+	return &fs.PathError{Op: "rename", Path: old, Err: ErrReadOnly}
+}
+
+func (h *HTTP) Delete(name string) error {
+	return &fs.PathError{Op: "delete", Path: name, Err: ErrReadOnly}
+}
+
+func (h *HTTP) ReadOnly() bool {
+	return true
+}
+
+func (h *HTTP) Close() error {
+	h.c.CloseIdleConnections()
+	return nil
+}
+
+func (h *HTTP) String() string {
+	return h.url
+}
+
+// WriteIndex creates the index files of dir and its subfolders in s, so that the content can be published on
+// a static web server and opened with OpenHTTP. Index files must be created again after the content changes
+func WriteIndex(s Storage, dir string) error {
+	ls, err := s.ReadDir(dir, IncludeHiddenFiles)
+	if core.IsErr(err, "cannot list %s in %s: %v", dir, s) {
+		return err
+	}
+
+	entries := []indexEntry{}
+	for _, l := range ls {
+		if l.Name() == IndexFile || IsTemp(l.Name()) {
+			continue
+		}
+		entries = append(entries, indexEntry{Name: l.Name(), Size: l.Size(), ModTime: l.ModTime(), IsDir: l.IsDir()})
+		if l.IsDir() {
+			err = WriteIndex(s, path.Join(dir, l.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = WriteJSON(s, path.Join(dir, IndexFile), entries, nil)
+	if core.IsErr(err, "cannot write index for %s in %s: %v", dir, s) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenStorage("file://" + dir)
+	require.NoError(t, err)
+	for n, c := range archiveFiles {
+		require.NoError(t, WriteFile(l, n, []byte(c)))
+	}
+	require.NoError(t, WriteIndex(l, ""))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	s, err := OpenStorage(srv.URL + "/pool")
+	require.NoError(t, err)
+	defer s.Close()
+	assert.True(t, IsReadOnly(s))
+
+	data, err := ReadFile(s, "feeds/20230101/1.body")
+	require.NoError(t, err)
+	assert.Equal(t, archiveFiles["pool/feeds/20230101/1.body"], string(data))
+
+	var b bytes.Buffer
+	require.NoError(t, s.Read("feeds/20230101/1.body", &Range{From: 5, To: 12}, &b, nil))
+	assert.Equal(t, "56789ab", b.String())
+
+	ls, err := s.ReadDir("feeds", 0)
+	require.NoError(t, err)
+	require.Len(t, ls, 1)
+	assert.Equal(t, "20230101", ls[0].Name())
+	assert.True(t, ls[0].IsDir())
+
+	ls, err = s.ReadDir("feeds", IncludeHiddenFiles)
+	require.NoError(t, err)
+	assert.Len(t, ls, 2)
+
+	stat, err := s.Stat("feeds/20230101/1.body")
+	require.NoError(t, err)
+	assert.EqualValues(t, 20, stat.Size())
+
+	_, err = s.Stat("missing")
+	assert.True(t, os.IsNotExist(err))
+	_, err = s.ReadDir("missing", 0)
+	assert.True(t, os.IsNotExist(err))
+	_, err = ReadFile(s, "missing")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.True(t, errors.Is(WriteFile(s, "new", []byte("x")), ErrReadOnly))
+	assert.True(t, errors.Is(s.Delete("identities/abc"), ErrReadOnly))
+}
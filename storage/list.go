@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// ListFilter selects the entries returned by a paginated listing
+type ListFilter struct {
+	Prefix     string     // only names that start with Prefix
+	StartAfter string     // only names greater than StartAfter
+	Limit      int        // maximal entries in a page; 0 for the backend default
+	Options    ListOption // e.g. IncludeHiddenFiles
+}
+
+// match returns true when name passes the filter
+func (f ListFilter) match(name string) bool {
+	if !strings.HasPrefix(name, f.Prefix) || name <= f.StartAfter {
+		return false
+	}
+	return f.Options&IncludeHiddenFiles != 0 || !isHidden(name)
+}
+
+// Lister is implemented by storages that list a folder one page at a time. Entries are sorted by name. The
+// returned token is passed to the next call to get the following page and it is empty on the last page
+type Lister interface {
+	List(dir string, filter ListFilter, token string) (infos []fs.FileInfo, next string, err error)
+}
+
+// ListPage returns a page of the entries in dir. Storages that do not implement Lister return all the
+// matching entries in a single page
+func ListPage(s Storage, dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	if l, ok := s.(Lister); ok {
+		return l.List(dir, filter, token)
+	}
+
+	ls, err := s.ReadDir(dir, filter.Options)
+	if err != nil {
+		return nil, "", err
+	}
+	var infos []fs.FileInfo
+	for _, l := range ls {
+		if filter.match(l.Name()) {
+			infos = append(infos, l)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, "", nil
+}
+
+// DirIterator returns the entries of a folder one at a time, loading a page when the previous one is consumed.
+//
+//	it := NewDirIterator(s, dir, ListFilter{StartAfter: last})
+//	for it.Next() {
+//		info := it.Info()
+//	}
+//	err := it.Err()
+type DirIterator struct {
+	s      Storage
+	dir    string
+	filter ListFilter
+	token  string
+	page   []fs.FileInfo
+	pos    int
+	done   bool
+	err    error
+}
+
+// NewDirIterator creates an iterator on the entries in dir that match filter
+func NewDirIterator(s Storage, dir string, filter ListFilter) *DirIterator {
+	return &DirIterator{s: s, dir: dir, filter: filter}
+}
+
+// Next moves to the next entry and returns false when there are no more entries or on error
+func (it *DirIterator) Next() bool {
+	it.pos++
+	for it.pos >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.page, it.token, it.err = ListPage(it.s, it.dir, it.filter, it.token)
+		it.pos = 0
+		it.done = it.token == ""
+	}
+	return it.err == nil
+}
+
+// Info returns the current entry
+func (it *DirIterator) Info() fs.FileInfo {
+	return it.page[it.pos]
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *DirIterator) Err() error {
+	return it.err
+}
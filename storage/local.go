@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/code-to-go/safepool/core"
+	"github.com/fsnotify/fsnotify"
 )
 
 type LocalConfig struct {
@@ -32,7 +33,7 @@ func OpenLocal(connectionUrl string) (Storage, error) {
 	if base == "" {
 		base = "/"
 	}
-	return &Local{base, connectionUrl, map[string]time.Time{}}, nil
+	return &Local{base, Redact(connectionUrl), map[string]time.Time{}}, nil
 }
 
 func (l *Local) GetCheckpoint(name string) int64 {
@@ -53,30 +54,23 @@ func (l *Local) SetCheckpoint(name string) (int64, error) {
 
 func (l *Local) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
 	f, err := os.Open(path.Join(l.base, name))
-	if core.IsErr(err, "cannot open file on %v:%v", l) {
+	if os.IsNotExist(err) || core.IsErr(err, "cannot open file on %v:%v", l) {
 		return err
 	}
+	defer f.Close()
+
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
 
 	if rang == nil {
-		_, err = io.Copy(dest, f)
+		_, err = io.Copy(pw, f)
 	} else {
-		left := rang.To - rang.From
-		f.Seek(rang.From, 0)
-		var b [4096]byte
-
-		for left > 0 && err == nil {
-			var sz int64
-			if rang.From-rang.To > 4096 {
-				sz = 4096
-			} else {
-				sz = rang.From - rang.To
-			}
-			_, err = f.Read(b[0:sz])
-			dest.Write(b[0:sz])
-			left -= sz
+		_, err = f.Seek(rang.From, 0)
+		if err == nil {
+			_, err = io.CopyN(pw, f, rang.To-rang.From)
 		}
 	}
-	if core.IsErr(err, "cannot read from %s/%s:%v", l, name) {
+	if err != io.EOF && core.IsErr(err, "cannot read from %s/%s:%v", l, name) {
 		return err
 	}
 
@@ -94,25 +88,40 @@ func (l *Local) Write(name string, source io.ReadSeeker, size int64, progress ch
 		return err
 	}
 
-	f, err := os.Create(n)
+	tmp := filepath.Join(filepath.Dir(n), tempName(filepath.Base(n)))
+	f, err := os.Create(tmp)
 	if core.IsErr(err, "cannot create file on %v:%v", l) {
 		return err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, source)
-	core.IsErr(err, "cannot copy file on %v:%v", l)
-	return err
+	pr := newProgressReader(source, progress)
+	defer pr.flush()
+
+	_, err = io.Copy(f, pr)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, n)
+	}
+	if core.IsErr(err, "cannot copy file on %v:%v", l) {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 func (l *Local) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
-	result, err := os.ReadDir(dir)
+	result, err := os.ReadDir(path.Join(l.base, dir))
 	if err != nil {
 		return nil, err
 	}
 
 	var infos []fs.FileInfo
 	for _, item := range result {
+		if opts&IncludeHiddenFiles == 0 && isHidden(item.Name()) {
+			continue
+		}
 		info, err := item.Info()
 		if err == nil {
 			infos = append(infos, info)
@@ -131,7 +140,94 @@ func (l *Local) Rename(old, new string) error {
 }
 
 func (l *Local) Delete(name string) error {
-	return os.Remove(path.Join(l.base, name))
+	n := path.Join(l.base, name)
+	_, err := os.Stat(n)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(n)
+}
+
+// Watch uses the notifications of the operating system (e.g. inotify on Linux). Subfolders are watched as
+// soon as they are created
+func (l *Local) Watch(dir string, stop chan struct{}) (chan string, error) {
+	w, err := fsnotify.NewWatcher()
+	if core.IsErr(err, "cannot create watcher on %s: %v", l) {
+		return nil, err
+	}
+
+	root := filepath.Join(l.base, dir)
+	err = os.MkdirAll(root, 0755)
+	if err == nil {
+		_, err = addWatches(w, root)
+	}
+	if core.IsErr(err, "cannot watch %s in %s: %v", dir, l) {
+		w.Close()
+		return nil, err
+	}
+
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		defer w.Close()
+		for {
+			var changed []string
+			select {
+			case <-stop:
+				return
+			case err := <-w.Errors:
+				core.IsErr(err, "watch error on %s: %v", l)
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 || IsTemp(ev.Name) {
+					continue
+				}
+				changed = append(changed, ev.Name)
+				if stat, err := os.Stat(ev.Name); err == nil && stat.IsDir() {
+					// files may be created before the folder is watched
+					files, _ := addWatches(w, ev.Name)
+					changed = append(changed, files...)
+				}
+			}
+
+			for _, c := range changed {
+				name, err := filepath.Rel(l.base, c)
+				if err != nil || IsTemp(name) {
+					continue
+				}
+				select {
+				case ch <- filepath.ToSlash(name):
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// addWatches watches a folder and its subfolders and returns the files they contain
+func addWatches(w *fsnotify.Watcher, root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir():
+			return w.Add(p)
+		case p != root:
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Presign is not supported since a file url cannot enforce the expiry and would reveal the base path
+func (l *Local) Presign(name string, expiry time.Duration) (string, error) {
+	return "", ErrNotSupported
 }
 
 func (l *Local) Close() error {
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"time"
 
@@ -9,64 +12,118 @@ import (
 	"github.com/godruoyi/go-snowflake"
 )
 
-type lockFileContent struct {
-	Id   uint64
-	Span time.Duration
+var ErrLocked = errors.New("the lock is held by another owner")
+var ErrLeaseLost = errors.New("the lease expired and the lock was acquired by another owner")
+
+// leaseContent is the content of a lock file
+type leaseContent struct {
+	Id      uint64    `json:"id"`
+	Expires time.Time `json:"expires"`
 }
 
-func waitLock(e Storage, name string) {
-	var c lockFileContent
-	var err error
-	var id uint64
-	var shot time.Time
+// Lease is a lock on a storage, held until it is released or it expires. The lock is a file updated with
+// conditional writes, so the storage must be a ConditionalWriter. Expiry uses the synchronized time of
+// core.Now, so clocks of different devices must be in sync
+type Lease struct {
+	Id      uint64
+	Expires time.Time
 
-	for shot.IsZero() && core.Since(shot) > c.Span {
-		id = c.Id
-		_, err = e.Stat(name)
-		if os.IsNotExist(err) {
-			return
-		}
-		err = ReadJSON(e, name, &c, nil)
-		if err != nil {
-			return
-		}
-		if c.Id != id {
-			shot = core.Now()
+	s    Storage
+	name string
+	span time.Duration
+	etag string
+}
+
+// readLease returns the current content and ETag of a lock file. A missing file returns an empty ETag and a
+// content that cannot be parsed returns an expired lease
+func readLease(s Storage, name string) (leaseContent, string, error) {
+	var b bytes.Buffer
+	etag, err := ReadWithETag(s, name, &b)
+	if os.IsNotExist(err) {
+		return leaseContent{}, "", nil
+	}
+	if err != nil {
+		return leaseContent{}, "", err
+	}
+
+	var c leaseContent
+	if json.Unmarshal(b.Bytes(), &c) != nil {
+		core.Info("invalid lock file %s in %s; consider it expired", name, s)
+	}
+	return c, etag, nil
+}
+
+// write saves the lease with a conditional write on the previous ETag
+func (l *Lease) write(expires time.Time, cond Condition) error {
+	data, err := json.Marshal(leaseContent{Id: l.Id, Expires: expires})
+	if err != nil {
+		return err
+	}
+	etag, err := WriteIf(l.s, l.name, core.NewBytesReader(data), int64(len(data)), cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		// the write may have succeeded on a previous attempt whose response was lost
+		c, current, rerr := readLease(l.s, l.name)
+		if rerr == nil && c.Id == l.Id && c.Expires.Equal(expires) {
+			etag, err = current, nil
 		}
-		time.Sleep(time.Second)
 	}
+	if err != nil {
+		return err
+	}
+	l.etag, l.Expires = etag, expires
+	return nil
 }
 
-func LockFile(e Storage, name string, span time.Duration) (uint64, error) {
-	var c lockFileContent
-	id := snowflake.ID()
-	for c.Id != id {
-		waitLock(e, name)
+// AcquireLease acquires the lock file name for span. It returns ErrLocked when another owner holds a lease
+// that is not expired and ErrNotSupported when s does not support conditional writes
+func AcquireLease(s Storage, name string, span time.Duration) (*Lease, error) {
+	c, etag, err := readLease(s, name)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" && core.Now().Before(c.Expires) {
+		return nil, ErrLocked
+	}
 
-		err := WriteJSON(e, name, lockFileContent{
-			Id:   id,
-			Span: span,
-		}, nil)
-		if core.IsErr(err, "cannot write lock file %s: %v", name) {
-			return 0, err
-		}
-		time.Sleep(time.Second)
-		err = ReadJSON(e, name, &c, nil)
-		if core.IsErr(err, "cannot read lock file %s: %v", name) {
-			return 0, err
+	l := &Lease{Id: snowflake.ID(), s: s, name: name, span: span}
+	cond := Condition{IfNoneMatch: etag == "", IfMatch: etag}
+	err = l.write(core.Now().Add(span), cond)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// WaitLease is like AcquireLease but waits until the lock is free or timeout is elapsed
+func WaitLease(s Storage, name string, span time.Duration, timeout time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := AcquireLease(s, name, span)
+		if err != ErrLocked || time.Now().After(deadline) {
+			return l, err
 		}
+		time.Sleep(time.Duration(100+snowflake.ID()%400) * time.Millisecond)
 	}
-	return id, nil
 }
 
-func UnlockFile(e Storage, name string, id uint64) {
-	var c lockFileContent
-	_, err := e.Stat(name)
-	if os.IsNotExist(err) {
-		return
+// Renew extends the lease for another span. It returns ErrLeaseLost when the lease expired and another
+// owner acquired the lock
+func (l *Lease) Renew() error {
+	err := l.write(core.Now().Add(l.span), Condition{IfMatch: l.etag})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return ErrLeaseLost
 	}
-	_ = ReadJSON(e, name, &c, nil)
-	if c.Id == id {
-		e.Delete(name)
+	return err
+}
+
+// Release frees the lock, so that other owners do not wait for the expiry
+func (l *Lease) Release() error {
+	err := l.write(time.Time{}, Condition{IfMatch: l.etag})
+	if errors.Is(err, ErrPreconditionFailed) {
+		return ErrLeaseLost
 	}
+	return err
 }
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+type memFS struct {
+	files map[string]memFile
+	mutex sync.Mutex
+}
+
+var memFSs = map[string]*memFS{}
+var memFSsMutex sync.Mutex
+
+// Memory is an in-process storage. Instances opened with the same name share the same content, so that
+// multiple users can be simulated in a single process. Content is lost when the process ends.
+type Memory struct {
+	fs   *memFS
+	base string
+	url  string
+}
+
+// OpenMemory opens an in-memory storage. The url is in the format mem://name/base
+func OpenMemory(connectionUrl string) (Storage, error) {
+	u, err := url.Parse(connectionUrl)
+	if core.IsErr(err, "invalid URL: %v") {
+		return nil, err
+	}
+	if u.Host == "" {
+		core.IsErr(os.ErrInvalid, "missing name in memory url '%s': %v", Redact(connectionUrl))
+		return nil, os.ErrInvalid
+	}
+
+	memFSsMutex.Lock()
+	m, ok := memFSs[u.Host]
+	if !ok {
+		m = &memFS{files: map[string]memFile{}}
+		memFSs[u.Host] = m
+	}
+	memFSsMutex.Unlock()
+
+	return &Memory{m, strings.Trim(u.Path, "/"), Redact(connectionUrl)}, nil
+}
+
+func (m *Memory) key(name string) string {
+	return strings.Trim(path.Join(m.base, name), "/")
+}
+
+func (m *Memory) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	k := m.key(name)
+	m.fs.mutex.Lock()
+	f, ok := m.fs.files[k]
+	m.fs.mutex.Unlock()
+	if !ok {
+		return &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+
+	data := f.data
+	if rang != nil {
+		from, to := rang.From, rang.To
+		if to > int64(len(data)) {
+			to = int64(len(data))
+		}
+		if from > to {
+			from = to
+		}
+		data = data[from:to]
+	}
+
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
+
+	_, err := pw.Write(data)
+	if core.IsErr(err, "cannot read from %s/%s:%v", m, name) {
+		return err
+	}
+	return nil
+}
+
+func (m *Memory) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	pr := newProgressReader(source, progress)
+	defer pr.flush()
+
+	var b bytes.Buffer
+	_, err := io.Copy(&b, pr)
+	if core.IsErr(err, "cannot write %s/%s: %v", m, name) {
+		return err
+	}
+
+	m.fs.mutex.Lock()
+	m.fs.files[m.key(name)] = memFile{b.Bytes(), core.Now()}
+	m.fs.mutex.Unlock()
+	return nil
+}
+
+func (m *Memory) ReadWithETag(name string, dest io.Writer) (string, error) {
+	m.fs.mutex.Lock()
+	f, ok := m.fs.files[m.key(name)]
+	m.fs.mutex.Unlock()
+	if !ok {
+		return "", &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+
+	_, err := dest.Write(f.data)
+	if core.IsErr(err, "cannot read from %s/%s:%v", m, name) {
+		return "", err
+	}
+	return contentTag(f.data), nil
+}
+
+func (m *Memory) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	var b bytes.Buffer
+	_, err := io.Copy(&b, source)
+	if core.IsErr(err, "cannot write %s/%s: %v", m, name) {
+		return "", err
+	}
+
+	k := m.key(name)
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+
+	f, ok := m.fs.files[k]
+	if cond.IfNoneMatch && ok || cond.IfMatch != "" && (!ok || contentTag(f.data) != cond.IfMatch) {
+		return "", &fs.PathError{Op: "write", Path: name, Err: ErrPreconditionFailed}
+	}
+	m.fs.files[k] = memFile{b.Bytes(), core.Now()}
+	return contentTag(b.Bytes()), nil
+}
+
+func (m *Memory) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	prefix := m.key(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+
+	entries := map[string]simpleFileInfo{}
+	for k, f := range m.fs.files {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name, rest, isDir := strings.Cut(k[len(prefix):], "/")
+		if opts&IncludeHiddenFiles == 0 && isHidden(name) {
+			entries[name] = simpleFileInfo{}
+			continue
+		}
+
+		info := entries[name]
+		switch {
+		case isDir && rest != "":
+			info.name, info.isDir = name, true
+			if f.modTime.After(info.modTime) {
+				info.modTime = f.modTime
+			}
+		case !isDir:
+			info = simpleFileInfo{name: name, size: int64(len(f.data)), modTime: f.modTime}
+		}
+		entries[name] = info
+	}
+	if len(entries) == 0 && prefix != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+
+	var infos []fs.FileInfo
+	for _, info := range entries {
+		if info.name != "" {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (m *Memory) Stat(name string) (os.FileInfo, error) {
+	k := m.key(name)
+
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+
+	if f, ok := m.fs.files[k]; ok {
+		return simpleFileInfo{name: path.Base(k), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+
+	info := simpleFileInfo{name: path.Base(k), isDir: true}
+	for n, f := range m.fs.files {
+		if k == "" || strings.HasPrefix(n, k+"/") {
+			if f.modTime.After(info.modTime) {
+				info.modTime = f.modTime
+			}
+		}
+	}
+	if info.modTime.IsZero() {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (m *Memory) Rename(old, new string) error {
+	o, n := m.key(old), m.key(new)
+
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+
+	if f, ok := m.fs.files[o]; ok {
+		delete(m.fs.files, o)
+		m.fs.files[n] = f
+		return nil
+	}
+
+	found := false
+	for k, f := range m.fs.files {
+		if strings.HasPrefix(k, o+"/") {
+			delete(m.fs.files, k)
+			m.fs.files[n+k[len(o):]] = f
+			found = true
+		}
+	}
+	if !found {
+		return &fs.PathError{Op: "rename", Path: old, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *Memory) Delete(name string) error {
+	k := m.key(name)
+
+	m.fs.mutex.Lock()
+	defer m.fs.mutex.Unlock()
+
+	_, found := m.fs.files[k]
+	delete(m.fs.files, k)
+	for n := range m.fs.files {
+		if k == "" || strings.HasPrefix(n, k+"/") {
+			delete(m.fs.files, n)
+			found = true
+		}
+	}
+	if !found {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) String() string {
+	return m.url
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	url := "mem://" + uuid.New().String()
+	s, err := OpenStorage(url)
+	assert.NoErrorf(t, err, "cannot open memory storage: %v", err)
+	testCreateFile(t, s)
+
+	data := []byte("0123456789")
+	assert.NoError(t, WriteFile(s, "a/b/c.txt", data))
+	assert.NoError(t, WriteFile(s, "a/.hidden", nil))
+
+	var b bytes.Buffer
+	assert.NoError(t, s.Read("a/b/c.txt", &Range{From: 2, To: 5}, &b, nil))
+	assert.Equal(t, "234", b.String())
+
+	ls, err := s.ReadDir("a", 0)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 1)
+	assert.True(t, ls[0].IsDir())
+	assert.Equal(t, "b", ls[0].Name())
+
+	ls, err = s.ReadDir("a", IncludeHiddenFiles)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 2)
+
+	stat, err := s.Stat("a/b/c.txt")
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(data), stat.Size())
+	assert.False(t, stat.ModTime().IsZero())
+
+	s2, err := OpenStorage(url)
+	assert.NoError(t, err)
+	assert.NoError(t, s2.Rename("a/b/c.txt", "a/d.txt"))
+	_, err = s.Stat("a/b/c.txt")
+	assert.True(t, os.IsNotExist(err))
+
+	got, err := ReadFile(s, "a/d.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	assert.NoError(t, s.Delete("a"))
+	_, err = s2.ReadDir("a", 0)
+	assert.True(t, os.IsNotExist(err))
+}
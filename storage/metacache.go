@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// DefaultCacheTTL is the validity of cached metadata when the cache parameter has no duration
+var DefaultCacheTTL = 30 * time.Second
+
+// MaxCacheEntries limits the entries of a MetaCache. Expired entries are purged when the limit is reached
+var MaxCacheEntries = 10000
+
+// CacheRule sets the TTL of the paths that match Pattern. A pattern without a slash matches a name at any
+// level, e.g. feeds matches pool/feeds and pool/feeds/20230101; a pattern with a slash matches the full path
+// or one of its parents with path.Match. A TTL of 0 disables caching
+type CacheRule struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// CacheConfig is the configuration of a MetaCache. Rules are checked in order and TTL applies to paths that
+// match no rule
+type CacheConfig struct {
+	TTL   time.Duration
+	Rules []CacheRule
+}
+
+// ParseCacheConfig parses a configuration in the format ttl[,pattern=ttl...], e.g. 1m,feeds=5s,.access=10m.
+// An empty ttl means DefaultCacheTTL
+func ParseCacheConfig(spec string) (CacheConfig, error) {
+	parts := strings.Split(spec, ",")
+	c := CacheConfig{TTL: DefaultCacheTTL}
+
+	var err error
+	if v := strings.TrimSpace(parts[0]); v != "" && v != "true" {
+		c.TTL, err = time.ParseDuration(v)
+		if core.IsErr(err, "invalid cache ttl '%s': %v", v) {
+			return CacheConfig{}, err
+		}
+	}
+	for _, r := range parts[1:] {
+		pattern, v, ok := strings.Cut(strings.TrimSpace(r), "=")
+		if !ok || pattern == "" {
+			core.IsErr(os.ErrInvalid, "invalid cache rule '%s': %v", r)
+			return CacheConfig{}, os.ErrInvalid
+		}
+		ttl, err := time.ParseDuration(v)
+		if core.IsErr(err, "invalid ttl in cache rule '%s': %v", r) {
+			return CacheConfig{}, err
+		}
+		c.Rules = append(c.Rules, CacheRule{Pattern: pattern, TTL: ttl})
+	}
+	return c, nil
+}
+
+func (c CacheConfig) String() string {
+	s := c.TTL.String()
+	for _, r := range c.Rules {
+		s += fmt.Sprintf(",%s=%v", r.Pattern, r.TTL)
+	}
+	return s
+}
+
+type cacheEntry struct {
+	path    string
+	expires time.Time
+
+	info  fs.FileInfo
+	infos []fs.FileInfo
+	next  string
+	err   error
+}
+
+// MetaCache keeps the results of Stat, ReadDir and List for a TTL, so that high latency exchanges are not
+// queried again for the same folders. The entries of a path are invalidated by the writes through the
+// cache. Guard files are never cached and a change of their modification time invalidates their folder, so
+// changes by other writers are seen as soon as the guard is checked
+type MetaCache struct {
+	s       Storage
+	config  CacheConfig
+	entries map[string]*cacheEntry
+	guards  map[string]time.Time
+	mutex   sync.Mutex
+}
+
+// NewMetaCache wraps s with a metadata cache
+func NewMetaCache(s Storage, config CacheConfig) *MetaCache {
+	return &MetaCache{
+		s:       s,
+		config:  config,
+		entries: map[string]*cacheEntry{},
+		guards:  map[string]time.Time{},
+	}
+}
+
+func cacheFromUrl(s Storage, query map[string][]string) (Storage, error) {
+	if queryValue(query, "cache") == "" {
+		return s, nil
+	}
+	return wrapCache(s, query)
+}
+
+// wrapCache wraps s with the cache parameter of the url. The default TTL applies when it is missing
+func wrapCache(s Storage, query map[string][]string) (Storage, error) {
+	c, err := ParseCacheConfig(queryValue(query, "cache"))
+	if err != nil {
+		return nil, err
+	}
+	return NewMetaCache(s, c), nil
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + name)[1:]
+}
+
+func (c *MetaCache) ttl(name string) time.Duration {
+	for _, r := range c.config.Rules {
+		if matchRule(r.Pattern, name) {
+			return r.TTL
+		}
+	}
+	return c.config.TTL
+}
+
+func matchRule(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, n := range strings.Split(name, "/") {
+			if ok, _ := path.Match(pattern, n); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for p := name; p != "." && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(strings.Trim(pattern, "/"), p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the entry for key when it is still valid
+func (c *MetaCache) get(key string) (*cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if core.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e, true
+}
+
+// set stores the entry when the TTL of its path is not 0. Only missing files are cached among errors
+func (c *MetaCache) set(key string, e *cacheEntry) {
+	if e.err != nil && !os.IsNotExist(e.err) {
+		return
+	}
+	ttl := c.ttl(e.path)
+	if ttl <= 0 {
+		return
+	}
+	e.expires = core.Now().Add(ttl)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= MaxCacheEntries {
+		c.purge()
+	}
+	c.entries[key] = e
+}
+
+func (c *MetaCache) purge() {
+	now := core.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) >= MaxCacheEntries {
+		c.entries = map[string]*cacheEntry{}
+	}
+}
+
+// invalidate drops the entries of name, of its content when recursive and the listings and stats of its
+// parents, which may show a new or removed entry
+func (c *MetaCache) invalidate(name string, recursive bool) {
+	name = cleanPath(name)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, e := range c.entries {
+		switch {
+		case e.path == name, recursive && strings.HasPrefix(e.path, name+"/"):
+			delete(c.entries, k)
+		case e.path == "" || strings.HasPrefix(name, e.path+"/"):
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Invalidate drops the cached metadata of name and its content
+func (c *MetaCache) Invalidate(name string) {
+	c.invalidate(name, true)
+}
+
+func (c *MetaCache) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	return c.s.Read(name, rang, dest, progress)
+}
+
+func (c *MetaCache) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	err := c.s.Write(name, source, size, progress)
+	c.invalidate(name, false)
+	return err
+}
+
+func (c *MetaCache) ReadWithETag(name string, dest io.Writer) (string, error) {
+	return ReadWithETag(c.s, name, dest)
+}
+
+func (c *MetaCache) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	etag, err := WriteIf(c.s, name, source, size, cond)
+	c.invalidate(name, false)
+	return etag, err
+}
+
+func (c *MetaCache) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	key := fmt.Sprintf("d%d:%s", opts, cleanPath(dir))
+	if e, ok := c.get(key); ok {
+		return append([]fs.FileInfo(nil), e.infos...), e.err
+	}
+
+	infos, err := c.s.ReadDir(dir, opts)
+	c.set(key, &cacheEntry{path: cleanPath(dir), infos: append([]fs.FileInfo(nil), infos...), err: err})
+	return infos, err
+}
+
+func (c *MetaCache) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	key := fmt.Sprintf("l%d:%s:%s:%d:%s:%s", filter.Options, filter.Prefix, filter.StartAfter, filter.Limit, token,
+		cleanPath(dir))
+	if e, ok := c.get(key); ok {
+		return append([]fs.FileInfo(nil), e.infos...), e.next, e.err
+	}
+
+	infos, next, err := ListPage(c.s, dir, filter, token)
+	c.set(key, &cacheEntry{path: cleanPath(dir), infos: append([]fs.FileInfo(nil), infos...), next: next, err: err})
+	return infos, next, err
+}
+
+// Stat returns the cached stat of name. Guard files are always read from the storage
+func (c *MetaCache) Stat(name string) (os.FileInfo, error) {
+	if path.Base(name) == GuardFile {
+		return c.statGuard(name)
+	}
+
+	key := "s:" + cleanPath(name)
+	if e, ok := c.get(key); ok {
+		return e.info, e.err
+	}
+
+	info, err := c.s.Stat(name)
+	c.set(key, &cacheEntry{path: cleanPath(name), info: info, err: err})
+	return info, err
+}
+
+// statGuard invalidates the folder of a guard file when the modification time of the guard changes
+func (c *MetaCache) statGuard(name string) (os.FileInfo, error) {
+	info, err := c.s.Stat(name)
+	if err != nil {
+		return info, err
+	}
+
+	name = cleanPath(name)
+	c.mutex.Lock()
+	last, ok := c.guards[name]
+	c.guards[name] = info.ModTime()
+	c.mutex.Unlock()
+
+	if ok && !last.Equal(info.ModTime()) {
+		core.Debug("guard %s changed in %s: invalidate cache", name, c.s)
+		c.invalidate(path.Dir(name), true)
+	}
+	return info, err
+}
+
+func (c *MetaCache) Rename(old, new string) error {
+	err := c.s.Rename(old, new)
+	c.invalidate(old, true)
+	c.invalidate(new, true)
+	return err
+}
+
+func (c *MetaCache) Delete(name string) error {
+	err := c.s.Delete(name)
+	c.invalidate(name, true)
+	return err
+}
+
+// Watch forwards the notifications of the storage and invalidates the paths that changed
+func (c *MetaCache) Watch(dir string, stop chan struct{}) (chan string, error) {
+	ch, err := watchInner(c.s, dir, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		for n := range ch {
+			c.invalidate(n, false)
+			select {
+			case out <- n:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (c *MetaCache) Presign(name string, expiry time.Duration) (string, error) {
+	return Presign(c.s, name, expiry)
+}
+
+func (c *MetaCache) ReadOnly() bool {
+	return IsReadOnly(c.s)
+}
+
+func (c *MetaCache) Close() error {
+	return c.s.Close()
+}
+
+func (c *MetaCache) String() string {
+	return c.s.String()
+}
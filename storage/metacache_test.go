@@ -0,0 +1,91 @@
+package storage_test
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/code-to-go/safepool/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the metadata requests that reach the storage
+type countingStorage struct {
+	storage.Storage
+	stats, readDirs int
+}
+
+func (c *countingStorage) Stat(name string) (os.FileInfo, error) {
+	c.stats++
+	return c.Storage.Stat(name)
+}
+
+func (c *countingStorage) ReadDir(name string, opts storage.ListOption) ([]fs.FileInfo, error) {
+	c.readDirs++
+	return c.Storage.ReadDir(name, opts)
+}
+
+func TestMetaCache(t *testing.T) {
+	m, err := storage.OpenStorage("mem://" + uuid.New().String())
+	require.NoError(t, err)
+	inner := &countingStorage{Storage: m}
+	config, err := storage.ParseCacheConfig("1m,feeds=0s")
+	require.NoError(t, err)
+	assert.Equal(t, "1m0s,feeds=0s", config.String())
+	c := storage.NewMetaCache(inner, config)
+	defer c.Close()
+
+	require.NoError(t, storage.WriteFile(c, "pool/a/x", []byte("x")))
+	for i := 0; i < 2; i++ {
+		_, err = c.Stat("pool/a/x")
+		assert.NoError(t, err)
+		_, err = c.Stat("pool/a/missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		ls, err := c.ReadDir("pool/a", 0)
+		assert.NoError(t, err)
+		assert.Len(t, ls, 1)
+	}
+	assert.Equal(t, 2, inner.stats)
+	assert.Equal(t, 1, inner.readDirs)
+
+	// writes through the cache invalidate the folder
+	require.NoError(t, storage.WriteFile(c, "pool/a/y", []byte("y")))
+	ls, err := c.ReadDir("pool/a", 0)
+	assert.NoError(t, err)
+	assert.Len(t, ls, 2)
+	require.NoError(t, c.Delete("pool/a/x"))
+	_, err = c.Stat("pool/a/x")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// rules with 0 TTL are not cached
+	require.NoError(t, storage.WriteFile(c, "pool/feeds/h", []byte("h")))
+	inner.stats = 0
+	c.Stat("pool/feeds/h")
+	c.Stat("pool/feeds/h")
+	assert.Equal(t, 2, inner.stats)
+
+	// changes by other writers are seen when the guard file changes
+	require.NoError(t, storage.WriteFile(m, "pool/a/.touch", nil))
+	_, err = c.Stat("pool/a/.touch")
+	assert.NoError(t, err)
+	ls, _ = c.ReadDir("pool/a", storage.IncludeHiddenFiles)
+	assert.Len(t, ls, 2)
+	require.NoError(t, storage.WriteFile(m, "pool/a/z", []byte("z")))
+	ls, _ = c.ReadDir("pool/a", storage.IncludeHiddenFiles)
+	assert.Len(t, ls, 2)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, storage.WriteFile(m, "pool/a/.touch", nil))
+	_, err = c.Stat("pool/a/.touch")
+	assert.NoError(t, err)
+	ls, _ = c.ReadDir("pool/a", storage.IncludeHiddenFiles)
+	assert.Len(t, ls, 3)
+
+	_, err = storage.ParseCacheConfig("1m,feeds")
+	assert.Error(t, err)
+	s, err := storage.OpenStorage("mem://" + uuid.New().String() + "?cache=10s")
+	require.NoError(t, err)
+	assert.IsType(t, &storage.MetaCache{}, s)
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram. The last bucket of a histogram counts the
+// operations slower than the last bound
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// OpMetrics are the statistics of an operation on an exchange. BytesIn are the bytes received from the
+// exchange, BytesOut the bytes sent to it. Missing files are counted in NotFound and not in Errors
+type OpMetrics struct {
+	Count     int64         `json:"count"`
+	Errors    int64         `json:"errors"`
+	NotFound  int64         `json:"notFound"`
+	BytesIn   int64         `json:"bytesIn"`
+	BytesOut  int64         `json:"bytesOut"`
+	Latency   time.Duration `json:"latency"`
+	Histogram []int64       `json:"histogram"`
+}
+
+// ErrorRate returns the ratio of failed operations
+func (o OpMetrics) ErrorRate() float64 {
+	if o.Count == 0 {
+		return 0
+	}
+	return float64(o.Errors) / float64(o.Count)
+}
+
+// MeanLatency returns the average duration of an operation
+func (o OpMetrics) MeanLatency() time.Duration {
+	if o.Count == 0 {
+		return 0
+	}
+	return o.Latency / time.Duration(o.Count)
+}
+
+// exchangeMetrics collects the statistics of an exchange. It is shared by all the connections to the same url
+type exchangeMetrics struct {
+	mutex sync.Mutex
+	ops   map[string]*OpMetrics
+}
+
+var metrics = map[string]*exchangeMetrics{}
+var metricsMutex sync.Mutex
+
+// Metrics wraps a storage and records count, transferred bytes, errors and latency of each operation. The
+// statistics are returned by GetMetrics
+type Metrics struct {
+	s Storage
+	m *exchangeMetrics
+}
+
+// NewMetrics wraps s with a Metrics. Statistics are collected per exchange, identified by s.String()
+func NewMetrics(s Storage) Storage {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	m, ok := metrics[s.String()]
+	if !ok {
+		m = &exchangeMetrics{ops: map[string]*OpMetrics{}}
+		metrics[s.String()] = m
+	}
+	return &Metrics{s, m}
+}
+
+// metricsFromUrl wraps s unless the connection url contains metrics=false
+func metricsFromUrl(s Storage, query map[string][]string) (Storage, error) {
+	if queryValue(query, "metrics") == "false" {
+		return s, nil
+	}
+	return NewMetrics(s), nil
+}
+
+func wrapMetrics(s Storage, query map[string][]string) (Storage, error) {
+	return NewMetrics(s), nil
+}
+
+// GetMetrics returns the statistics of the exchange with the provided url by operation
+func GetMetrics(exchange string) map[string]OpMetrics {
+	metricsMutex.Lock()
+	m, ok := metrics[exchange]
+	metricsMutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ops := map[string]OpMetrics{}
+	for op, o := range m.ops {
+		c := *o
+		c.Histogram = append([]int64(nil), o.Histogram...)
+		ops[op] = c
+	}
+	return ops
+}
+
+// AllMetrics returns the statistics of all the exchanges opened by the application
+func AllMetrics() map[string]map[string]OpMetrics {
+	metricsMutex.Lock()
+	var exchanges []string
+	for e := range metrics {
+		exchanges = append(exchanges, e)
+	}
+	metricsMutex.Unlock()
+
+	all := map[string]map[string]OpMetrics{}
+	for _, e := range exchanges {
+		all[e] = GetMetrics(e)
+	}
+	return all
+}
+
+// ResetMetrics clears the statistics of all the exchanges
+func ResetMetrics() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	for _, m := range metrics {
+		m.mutex.Lock()
+		m.ops = map[string]*OpMetrics{}
+		m.mutex.Unlock()
+	}
+}
+
+// record adds an operation to the statistics
+func (m *Metrics) record(op string, start time.Time, bytesIn, bytesOut int64, err error) {
+	elapsed := time.Since(start)
+
+	m.m.mutex.Lock()
+	defer m.m.mutex.Unlock()
+
+	o, ok := m.m.ops[op]
+	if !ok {
+		o = &OpMetrics{Histogram: make([]int64, len(LatencyBuckets)+1)}
+		m.m.ops[op] = o
+	}
+	o.Count++
+	o.BytesIn += bytesIn
+	o.BytesOut += bytesOut
+	o.Latency += elapsed
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		o.NotFound++
+	default:
+		o.Errors++
+	}
+
+	i := 0
+	for i < len(LatencyBuckets) && elapsed > LatencyBuckets[i] {
+		i++
+	}
+	o.Histogram[i]++
+}
+
+// countingReadSeeker counts the bytes read from a source, including the bytes read again after a Seek
+type countingReadSeeker struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (m *Metrics) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	start := time.Now()
+	cw := &countingWriter{w: dest}
+	err := m.s.Read(name, rang, cw, progress)
+	m.record("read", start, cw.n, 0, err)
+	return err
+}
+
+func (m *Metrics) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	start := time.Now()
+	cr := &countingReadSeeker{ReadSeeker: source}
+	err := m.s.Write(name, cr, size, progress)
+	m.record("write", start, 0, cr.n, err)
+	return err
+}
+
+func (m *Metrics) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	start := time.Now()
+	infos, err := m.s.ReadDir(name, opts)
+	m.record("readdir", start, 0, 0, err)
+	return infos, err
+}
+
+func (m *Metrics) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	start := time.Now()
+	infos, next, err := ListPage(m.s, dir, filter, token)
+	m.record("readdir", start, 0, 0, err)
+	return infos, next, err
+}
+
+func (m *Metrics) ReadWithETag(name string, dest io.Writer) (string, error) {
+	start := time.Now()
+	cw := &countingWriter{w: dest}
+	etag, err := ReadWithETag(m.s, name, cw)
+	m.record("read", start, cw.n, 0, err)
+	return etag, err
+}
+
+func (m *Metrics) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	start := time.Now()
+	cr := &countingReadSeeker{ReadSeeker: source}
+	etag, err := WriteIf(m.s, name, cr, size, cond)
+	m.record("write", start, 0, cr.n, err)
+	return etag, err
+}
+
+func (m *Metrics) Stat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	info, err := m.s.Stat(name)
+	m.record("stat", start, 0, 0, err)
+	return info, err
+}
+
+func (m *Metrics) Rename(old, new string) error {
+	start := time.Now()
+	err := m.s.Rename(old, new)
+	m.record("rename", start, 0, 0, err)
+	return err
+}
+
+func (m *Metrics) Delete(name string) error {
+	start := time.Now()
+	err := m.s.Delete(name)
+	m.record("delete", start, 0, 0, err)
+	return err
+}
+
+func (m *Metrics) Watch(dir string, stop chan struct{}) (chan string, error) {
+	return watchInner(m.s, dir, stop)
+}
+
+func (m *Metrics) Presign(name string, expiry time.Duration) (string, error) {
+	return Presign(m.s, name, expiry)
+}
+
+func (m *Metrics) ReadOnly() bool {
+	return IsReadOnly(m.s)
+}
+
+func (m *Metrics) Close() error {
+	return m.s.Close()
+}
+
+func (m *Metrics) String() string {
+	return m.s.String()
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s, err := OpenStorage("mem://" + uuid.New().String())
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, WriteFile(s, "a.txt", []byte("0123456789")))
+	data, err := ReadFile(s, "a.txt")
+	require.NoError(t, err)
+	assert.Len(t, data, 10)
+	_, err = s.Stat("missing")
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, s.Rename("missing", "b.txt"))
+
+	m := GetMetrics(s.String())
+	assert.EqualValues(t, 1, m["write"].Count)
+	assert.EqualValues(t, 10, m["write"].BytesOut)
+	assert.EqualValues(t, 1, m["read"].Count)
+	assert.EqualValues(t, 10, m["read"].BytesIn)
+	assert.EqualValues(t, 1, m["stat"].NotFound)
+	assert.EqualValues(t, 0, m["stat"].Errors)
+	assert.Len(t, m["read"].Histogram, len(LatencyBuckets)+1)
+	assert.EqualValues(t, 1, m["read"].Histogram[0])
+	assert.Contains(t, AllMetrics(), s.String())
+
+	s2, err := OpenStorage("mem://" + uuid.New().String() + "?metrics=false")
+	require.NoError(t, err)
+	defer s2.Close()
+	require.NoError(t, WriteFile(s2, "a.txt", nil))
+	assert.Nil(t, GetMetrics(s2.String()))
+}
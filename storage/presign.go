@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"time"
+)
+
+// MaxPresignExpiry is the longest validity of a presigned url. S3 does not accept longer expirations
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// Presigner is implemented by storages that can create a url to read a file without credentials
+type Presigner interface {
+	// Presign returns a url that allows anyone to read the file name until expiry elapses
+	Presign(name string, expiry time.Duration) (string, error)
+}
+
+// Presign returns a url to read the file name without credentials. It returns ErrNotSupported when s is not a
+// Presigner
+func Presign(s Storage, name string, expiry time.Duration) (string, error) {
+	p, ok := s.(Presigner)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	if expiry > MaxPresignExpiry {
+		expiry = MaxPresignExpiry
+	}
+	return p.Presign(name, expiry)
+}
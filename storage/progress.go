@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io"
+)
+
+// progressStep is the minimal number of bytes between two progress reports
+const progressStep = 64 * 1024
+
+// progressReader reports the bytes read from a source on a progress channel. Bytes read again after the source
+// is rewinded, e.g. when a client computes a checksum before the upload, are not reported twice
+type progressReader struct {
+	r        io.ReadSeeker
+	progress chan int64
+	pos      int64
+	top      int64
+	pending  int64
+}
+
+func newProgressReader(r io.ReadSeeker, progress chan int64) *progressReader {
+	return &progressReader{r: r, progress: progress}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+	if p.pos > p.top {
+		p.pending += p.pos - p.top
+		p.top = p.pos
+	}
+	if p.pending >= progressStep || err == io.EOF {
+		p.flush()
+	}
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.pos = pos
+	}
+	return pos, err
+}
+
+// flush sends the bytes not reported yet
+func (p *progressReader) flush() {
+	if p.progress != nil && p.pending > 0 {
+		p.progress <- p.pending
+	}
+	p.pending = 0
+}
+
+// progressWriter reports the bytes written to a destination on a progress channel
+type progressWriter struct {
+	w        io.Writer
+	progress chan int64
+	pending  int64
+}
+
+func newProgressWriter(w io.Writer, progress chan int64) *progressWriter {
+	return &progressWriter{w: w, progress: progress}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.pending += int64(n)
+	if p.pending >= progressStep {
+		p.flush()
+	}
+	return n, err
+}
+
+// flush sends the bytes not reported yet
+func (p *progressWriter) flush() {
+	if p.progress != nil && p.pending > 0 {
+		p.progress <- p.pending
+	}
+	p.pending = 0
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// Opener opens a storage from a connection url with the scheme of the driver
+type Opener func(connectionUrl string) (Storage, error)
+
+// Wrapper wraps a storage with the configuration in the query of the connection url
+type Wrapper func(s Storage, query map[string][]string) (Storage, error)
+
+var drivers = map[string]Opener{}
+var wrappers = map[string]Wrapper{}
+var registryMutex sync.RWMutex
+
+func init() {
+	Register("sftp", OpenSFTP)
+	Register("s3", OpenS3)
+	Register("azblob", OpenAzBlob)
+	Register("file", OpenLocal)
+	Register("dav", OpenWebDAV)
+	Register("davs", OpenWebDAV)
+	Register("mem", OpenMemory)
+	Register("archive", OpenArchive)
+	Register("http", OpenHTTP)
+	Register("https", OpenHTTP)
+
+	RegisterWrapper("metrics", wrapMetrics)
+	RegisterWrapper("chaos", wrapChaos)
+	RegisterWrapper("retry", wrapRetry)
+	RegisterWrapper("throttle", wrapThrottle)
+	RegisterWrapper("cache", wrapCache)
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "+:/")
+}
+
+// Register makes a driver available for the urls with the provided scheme. A new registration for the same
+// scheme replaces the previous driver
+func Register(scheme string, opener Opener) {
+	if !validName(scheme) {
+		core.IsErr(os.ErrInvalid, "invalid scheme '%s' for storage driver: %v", scheme)
+		return
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	drivers[scheme] = opener
+}
+
+// RegisterWrapper makes a wrapper available as a prefix of the scheme in a connection url, e.g. the wrapper
+// retry is used by retry+s3://host/bucket. Wrappers in the scheme can be chained and the rightmost is the
+// closest to the driver
+func RegisterWrapper(name string, wrapper Wrapper) {
+	if !validName(name) {
+		core.IsErr(os.ErrInvalid, "invalid name '%s' for storage wrapper: %v", name)
+		return
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	wrappers[name] = wrapper
+}
+
+// Schemes returns the schemes with a registered driver in alphabetical order
+func Schemes() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return sortedKeys(drivers)
+}
+
+// Wrappers returns the names of the registered wrappers in alphabetical order
+func Wrappers() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return sortedKeys(wrappers)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitScheme separates the wrappers in the scheme of a connection url from the url of the driver, e.g.
+// retry+metrics+s3://host/bucket returns [retry metrics], s3 and s3://host/bucket
+func splitScheme(connectionUrl string) (names []string, scheme string, driverUrl string) {
+	i := strings.Index(connectionUrl, ":")
+	if i < 0 {
+		return nil, "", connectionUrl
+	}
+
+	parts := strings.Split(connectionUrl[:i], "+")
+	scheme = parts[len(parts)-1]
+	names = parts[:len(parts)-1]
+	return names, scheme, connectionUrl[i-len(scheme):]
+}
+
+// lookup returns the driver for scheme and the wrappers with the provided names
+func lookup(scheme string, names []string) (Opener, []Wrapper, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	opener, ok := drivers[scheme]
+	if !ok {
+		core.IsErr(core.ErrNoDriver, "unknown storage scheme '%s': %v", scheme)
+		return nil, nil, core.ErrNoDriver
+	}
+
+	var ws []Wrapper
+	for _, n := range names {
+		w, ok := wrappers[n]
+		if !ok {
+			core.IsErr(core.ErrNoDriver, "unknown storage wrapper '%s': %v", n)
+			return nil, nil, core.ErrNoDriver
+		}
+		ws = append(ws, w)
+	}
+	return opener, ws, nil
+}
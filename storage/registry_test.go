@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	Register("test", func(connectionUrl string) (Storage, error) {
+		return OpenMemory("mem://" + strings.TrimPrefix(connectionUrl, "test://"))
+	})
+	defer func() {
+		registryMutex.Lock()
+		delete(drivers, "test")
+		registryMutex.Unlock()
+	}()
+	assert.Contains(t, Schemes(), "test")
+	assert.Contains(t, Schemes(), "s3")
+	assert.Equal(t, []string{"cache", "chaos", "metrics", "retry", "throttle"}, Wrappers())
+
+	name := uuid.New().String()
+	s, err := OpenStorage("test://" + name)
+	require.NoError(t, err)
+	assert.IsType(t, &Metrics{}, s)
+	require.NoError(t, WriteFile(s, "a", []byte("hello")))
+	s.Close()
+
+	s, err = OpenStorage("retry+throttle+test://" + name + "?retry=3&rate=1000")
+	require.NoError(t, err)
+	defer s.Close()
+	r, ok := s.(*Retry)
+	require.True(t, ok)
+	assert.Equal(t, 3, r.policy.MaxAttempts)
+	th, ok := r.s.(*Throttle)
+	require.True(t, ok)
+	assert.EqualValues(t, 1000, th.l.Rate())
+	assert.IsType(t, &Metrics{}, th.s)
+	data, err := ReadFile(s, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	s, err = OpenStorage("metrics+mem://" + name)
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, s.(*Metrics).s)
+	s.Close()
+
+	_, err = OpenStorage("unknown://" + name)
+	assert.ErrorIs(t, err, core.ErrNoDriver)
+	_, err = OpenStorage("unknown+mem://" + name)
+	assert.ErrorIs(t, err, core.ErrNoDriver)
+}
@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/pkg/sftp"
+	"github.com/studio-b12/gowebdav"
+)
+
+// RetryPolicy defines how failed operations are retried by the Retry wrapper
+type RetryPolicy struct {
+	MaxAttempts int                  // maximal number of attempts, including the first one
+	BaseDelay   time.Duration        // delay before the first retry; it doubles at every attempt
+	MaxDelay    time.Duration        // upper limit for the delay between two attempts
+	Budget      time.Duration        // maximal time spent on a single operation, including retries
+	Retryable   func(err error) bool // classifies errors; IsRetryable is used when nil
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Budget:      time.Minute,
+}
+
+// Retry wraps a storage and retries idempotent operations that fail with a transient error. Read, Stat,
+// ReadDir and Delete are always retried, Write only when the source can be rewinded. Rename is not retried
+// since a second attempt after a lost response would fail.
+type Retry struct {
+	s      Storage
+	policy RetryPolicy
+}
+
+// NewRetry wraps s with the provided retry policy
+func NewRetry(s Storage, policy RetryPolicy) Storage {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsRetryable
+	}
+	return &Retry{s, policy}
+}
+
+// retryFromUrl wraps s when the connection url contains the retry parameter with the number of attempts.
+// An optional retryBudget parameter sets the maximal time for an operation (e.g. retryBudget=30s)
+func retryFromUrl(s Storage, query map[string][]string) (Storage, error) {
+	if queryValue(query, "retry") == "" {
+		return s, nil
+	}
+	return wrapRetry(s, query)
+}
+
+// wrapRetry wraps s with the retry and retryBudget parameters of the url. The default policy applies when
+// they are missing
+func wrapRetry(s Storage, query map[string][]string) (Storage, error) {
+	var err error
+	policy := DefaultRetryPolicy
+	if attempts := queryValue(query, "retry"); attempts != "" {
+		policy.MaxAttempts, err = strconv.Atoi(attempts)
+		if core.IsErr(err, "invalid retry parameter '%s': %v", attempts) {
+			return nil, err
+		}
+	}
+
+	if budget := queryValue(query, "retryBudget"); budget != "" {
+		policy.Budget, err = time.ParseDuration(budget)
+		if core.IsErr(err, "invalid retryBudget parameter '%s': %v", budget) {
+			return nil, err
+		}
+	}
+	return NewRetry(s, policy), nil
+}
+
+// queryValue returns the first value of key in the query of a connection url
+func queryValue(query map[string][]string, key string) string {
+	if vs := query[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// IsRetryable returns true when err is likely to be transient. Missing files, permission errors and
+// client errors returned by HTTP based services are permanent.
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrExist), errors.Is(err, fs.ErrPermission),
+		errors.Is(err, fs.ErrInvalid), errors.Is(err, context.Canceled):
+		return false
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		code := statusErr.HTTPStatusCode()
+		return code == 429 || code >= 500
+	}
+	var davErr gowebdav.StatusError
+	if errors.As(err, &davErr) {
+		return davErr.Status == 429 || davErr.Status >= 500
+	}
+	var sftpErr *sftp.StatusError
+	if errors.As(err, &sftpErr) {
+		return sftpErr.FxCode() == sftp.ErrSSHFxConnectionLost || sftpErr.FxCode() == sftp.ErrSSHFxNoConnection
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// delay returns the time to wait before the attempt-th retry, using exponential backoff with full jitter
+func (r *Retry) delay(attempt int) time.Duration {
+	d := r.policy.BaseDelay << attempt
+	if d <= 0 || d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (r *Retry) do(op string, name string, f func() error) error {
+	start := time.Now()
+	var err error
+	for attempt := 0; attempt < r.policy.MaxAttempts; attempt++ {
+		err = f()
+		if !r.policy.Retryable(err) {
+			return err
+		}
+
+		d := r.delay(attempt)
+		if attempt+1 == r.policy.MaxAttempts || r.policy.Budget > 0 && time.Since(start)+d > r.policy.Budget {
+			break
+		}
+		core.Info("retry %s on %s/%s in %v after error: %v", op, r.s, name, d, err)
+		time.Sleep(d)
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Read retries a failed read. When part of the content was already written to dest, the next attempt
+// continues from the first missing byte
+func (r *Retry) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	cw := &countingWriter{w: dest}
+	return r.do("read", name, func() error {
+		if cw.n == 0 {
+			return r.s.Read(name, rang, cw, progress)
+		}
+
+		var rest Range
+		if rang == nil {
+			stat, err := r.s.Stat(name)
+			if err != nil {
+				return err
+			}
+			rest = Range{From: cw.n, To: stat.Size()}
+		} else {
+			rest = Range{From: rang.From + cw.n, To: rang.To}
+		}
+		if rest.From >= rest.To {
+			return nil
+		}
+		return r.s.Read(name, &rest, cw, progress)
+	})
+}
+
+// Write retries a failed write only when source can be rewinded
+func (r *Retry) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	start, err := source.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return r.s.Write(name, source, size, progress)
+	}
+
+	first := true
+	return r.do("write", name, func() error {
+		if !first {
+			if _, err := source.Seek(start, io.SeekStart); core.IsErr(err, "cannot rewind %s: %v", name) {
+				return err
+			}
+		}
+		first = false
+		return r.s.Write(name, source, size, progress)
+	})
+}
+
+func (r *Retry) ReadDir(name string, opts ListOption) (infos []fs.FileInfo, err error) {
+	err = r.do("readdir", name, func() error {
+		infos, err = r.s.ReadDir(name, opts)
+		return err
+	})
+	return infos, err
+}
+
+func (r *Retry) List(dir string, filter ListFilter, token string) (infos []fs.FileInfo, next string, err error) {
+	err = r.do("readdir", dir, func() error {
+		infos, next, err = ListPage(r.s, dir, filter, token)
+		return err
+	})
+	return infos, next, err
+}
+
+func (r *Retry) Stat(name string) (info os.FileInfo, err error) {
+	err = r.do("stat", name, func() error {
+		info, err = r.s.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+func (r *Retry) Rename(old, new string) error {
+	return r.s.Rename(old, new)
+}
+
+func (r *Retry) Delete(name string) error {
+	return r.do("delete", name, func() error {
+		return r.s.Delete(name)
+	})
+}
+
+// ReadWithETag retries a failed read. The content is buffered, since it must match the ETag of the attempt
+// that succeeds
+func (r *Retry) ReadWithETag(name string, dest io.Writer) (etag string, err error) {
+	var b bytes.Buffer
+	err = r.do("read", name, func() error {
+		b.Reset()
+		etag, err = ReadWithETag(r.s, name, &b)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = dest.Write(b.Bytes())
+	return etag, err
+}
+
+// WriteIf retries a failed conditional write when source can be rewinded. When the response of a successful
+// write is lost, the next attempt fails with ErrPreconditionFailed, so callers should check the content
+func (r *Retry) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (etag string, err error) {
+	start, err := source.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return WriteIf(r.s, name, source, size, cond)
+	}
+
+	first := true
+	err = r.do("write", name, func() error {
+		if !first {
+			if _, err := source.Seek(start, io.SeekStart); core.IsErr(err, "cannot rewind %s: %v", name) {
+				return err
+			}
+		}
+		first = false
+		etag, err = WriteIf(r.s, name, source, size, cond)
+		return err
+	})
+	return etag, err
+}
+
+func (r *Retry) Watch(dir string, stop chan struct{}) (chan string, error) {
+	return watchInner(r.s, dir, stop)
+}
+
+func (r *Retry) Presign(name string, expiry time.Duration) (string, error) {
+	return Presign(r.s, name, expiry)
+}
+
+func (r *Retry) ReadOnly() bool {
+	return IsReadOnly(r.s)
+}
+
+func (r *Retry) Close() error {
+	return r.s.Close()
+}
+
+func (r *Retry) String() string {
+	return r.s.String()
+}
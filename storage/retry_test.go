@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// flaky fails the first failures calls to Read and Write. Reads fail after writing half of the content
+type flaky struct {
+	Storage
+	failures int
+}
+
+func (f *flaky) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	if f.failures > 0 {
+		f.failures--
+		var b bytes.Buffer
+		if err := f.Storage.Read(name, rang, &b, progress); err != nil {
+			return err
+		}
+		dest.Write(b.Bytes()[0 : b.Len()/2])
+		return syscall.ECONNRESET
+	}
+	return f.Storage.Read(name, rang, dest, progress)
+}
+
+func (f *flaky) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	if f.failures > 0 {
+		f.failures--
+		io.CopyN(io.Discard, source, size/2)
+		return syscall.ECONNRESET
+	}
+	return f.Storage.Write(name, source, size, progress)
+}
+
+func TestRetry(t *testing.T) {
+	m, err := OpenStorage("mem://" + uuid.New().String())
+	assert.NoError(t, err)
+
+	f := &flaky{Storage: m}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	r := NewRetry(f, policy)
+
+	data := []byte("0123456789abcdefghij")
+	f.failures = 2
+	assert.NoError(t, WriteFile(r, "a.txt", data))
+
+	f.failures = 2
+	got, err := ReadFile(r, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	f.failures = 1
+	var b bytes.Buffer
+	assert.NoError(t, r.Read("a.txt", &Range{From: 4, To: 12}, &b, nil))
+	assert.Equal(t, data[4:12], b.Bytes())
+
+	f.failures = 3
+	assert.ErrorIs(t, WriteFile(r, "a.txt", data), syscall.ECONNRESET)
+
+	_, err = r.Stat("missing.txt")
+	assert.True(t, os.IsNotExist(err))
+	assert.False(t, IsRetryable(err))
+	assert.False(t, IsRetryable(io.EOF))
+}
+
+func TestRetryFromUrl(t *testing.T) {
+	s, err := OpenStorage("mem://" + uuid.New().String() + "?retry=5&retryBudget=10s")
+	assert.NoError(t, err)
+	r, ok := s.(*Retry)
+	assert.True(t, ok)
+	assert.Equal(t, 5, r.policy.MaxAttempts)
+	assert.Equal(t, 10*time.Second, r.policy.Budget)
+}
@@ -10,7 +10,10 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/code-to-go/safepool/core"
 
@@ -22,34 +25,51 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
 	"github.com/aws/smithy-go/logging"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/sirupsen/logrus"
 )
 
 type S3 struct {
-	client *s3.Client
-	bucket string
-	url    string
+	client   *s3.Client
+	bucket   string
+	url      string
+	partSize int64
+	parallel int
 }
 
+// DefaultPartSize is the size of the parts in multipart uploads. Files larger than a part are uploaded
+// in multiple parts
+const DefaultPartSize = 8 * 1024 * 1024
+
+// MinPartSize is the smallest part accepted by S3 for all the parts but the last
+const MinPartSize = 5 * 1024 * 1024
+
+// DefaultParallelParts is the number of parts uploaded concurrently
+const DefaultParallelParts = 4
+
 type s3logger struct{}
 
 func (l s3logger) Logf(classification logging.Classification, format string, v ...interface{}) {
 	fmt.Printf(format, v...)
 }
 
+// OpenS3 creates a new S3 storage. The url is in the format s3://host/bucket?accessKey=...&secret=...
+// Optional parameters are region, tls=false for plain http endpoints, pathStyle=true for services
+// that do not support virtual hosted buckets, partSize in MB and parallel for multipart uploads
 func OpenS3(connectionUrl string) (Storage, error) {
 	u, err := url.Parse(connectionUrl)
-	if core.IsErr(err, "invalid url '%s': %v", connectionUrl) {
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
 		return nil, err
 	}
 
+	q := u.Query()
+	scheme := core.If(q.Get("tls") == "false", "http", "https")
 	r2Resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		return aws.Endpoint{
-			URL: fmt.Sprintf("https://%s", u.Host),
+			URL: fmt.Sprintf("%s://%s", scheme, u.Host),
 		}, nil
 	})
 
-	q := u.Query()
 	verbose := q.Get("verbose")
 	accessKey := q.Get("accessKey")
 	secret := q.Get("secret")
@@ -60,6 +80,9 @@ func OpenS3(connectionUrl string) (Storage, error) {
 		config.WithEndpointResolverWithOptions(r2Resolver),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secret, "")),
 	}
+	if region := q.Get("region"); region != "" {
+		options = append(options, config.WithRegion(region))
+	}
 	switch verbose {
 	case "1":
 		options = append(options,
@@ -77,11 +100,33 @@ func OpenS3(connectionUrl string) (Storage, error) {
 	if core.IsErr(err, "cannot create S3 config for %s:%v", repr) {
 		return nil, err
 	}
+	if cfg.Region == "" {
+		cfg.Region = "auto"
+	}
 
 	s := &S3{
-		client: s3.NewFromConfig(cfg),
-		url:    repr,
-		bucket: bucket,
+		client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = q.Get("pathStyle") == "true"
+		}),
+		url:      repr,
+		bucket:   bucket,
+		partSize: DefaultPartSize,
+		parallel: DefaultParallelParts,
+	}
+	if v := q.Get("partSize"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || int64(mb)*1024*1024 < MinPartSize {
+			core.IsErr(os.ErrInvalid, "invalid partSize '%s' in %s, the minimum is 5MB: %v", v, repr)
+			return nil, os.ErrInvalid
+		}
+		s.partSize = int64(mb) * 1024 * 1024
+	}
+	if v := q.Get("parallel"); v != "" {
+		s.parallel, err = strconv.Atoi(v)
+		if err != nil || s.parallel < 1 {
+			core.IsErr(os.ErrInvalid, "invalid parallel '%s' in %s: %v", v, repr)
+			return nil, os.ErrInvalid
+		}
 	}
 
 	err = s.createBucketIfNeeded()
@@ -129,15 +174,15 @@ func (s *S3) SetCheckpoint(name string) (int64, error) {
 }
 
 func (s *S3) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
-	// var r *string
-	// if rang != nil {
-	// 	r = aws.String(fmt.Sprintf("byte%d-%d", rang.From, rang.To))
-	// }
+	var r *string
+	if rang != nil {
+		r = aws.String(fmt.Sprintf("bytes=%d-%d", rang.From, rang.To-1))
+	}
 
 	rawObject, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: &s.bucket,
 		Key:    &name,
-		//		Range:  r,
+		Range:  r,
 	})
 	if err != nil {
 		err = s.mapError(err)
@@ -145,68 +190,207 @@ func (s *S3) Read(name string, rang *Range, dest io.Writer, progress chan int64)
 			return err
 		}
 	}
+	defer rawObject.Body.Close()
 
-	_, err = io.Copy(dest, rawObject.Body)
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
+
+	_, err = io.Copy(pw, rawObject.Body)
 	if core.IsErr(err, "cannot read %s/%s: %v", s, name) {
 		return err
 	}
 
-	rawObject.Body.Close()
 	return nil
 }
 
 func (s *S3) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	if size > s.partSize {
+		return s.writeMultipart(name, source, size, progress)
+	}
+
+	pr := newProgressReader(source, progress)
+	defer pr.flush()
+
 	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
 		Bucket:        &s.bucket,
 		Key:           &name,
-		Body:          source,
+		Body:          pr,
 		ContentLength: size,
 	})
 	core.IsErr(err, "cannot write %s/%s: %v", s, name)
 	return s.mapError(err)
 }
 
+func (s *S3) ReadWithETag(name string, dest io.Writer) (string, error) {
+	rawObject, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &name,
+	})
+	if err != nil {
+		err = s.mapError(err)
+		if os.IsNotExist(err) || core.IsErr(err, "cannot read %s/%s: %v", s, name) {
+			return "", err
+		}
+	}
+	defer rawObject.Body.Close()
+
+	_, err = io.Copy(dest, rawObject.Body)
+	if core.IsErr(err, "cannot read %s/%s: %v", s, name) {
+		return "", err
+	}
+	return aws.ToString(rawObject.ETag), nil
+}
+
+// WriteIf sends the If-None-Match and If-Match headers, supported by AWS and by most compatible services
+func (s *S3) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	header := func(o *s3.Options) {
+		if cond.IfNoneMatch {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("If-None-Match", "*"))
+		}
+		if cond.IfMatch != "" {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("If-Match", cond.IfMatch))
+		}
+	}
+
+	res, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:        &s.bucket,
+		Key:           &name,
+		Body:          source,
+		ContentLength: size,
+	}, header)
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.HTTPStatusCode(); {
+		case code == 412, code == 409, code == 404 && cond.IfMatch != "":
+			return "", &fs.PathError{Op: "write", Path: name, Err: ErrPreconditionFailed}
+		}
+	}
+	if core.IsErr(err, "cannot write %s/%s: %v", s, name) {
+		return "", s.mapError(err)
+	}
+	return aws.ToString(res.ETag), nil
+}
+
+// Presign returns a presigned GET url. S3 checks the expiry on every request
+func (s *S3) Presign(name string, expiry time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.client).PresignGetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &name,
+	}, s3.WithPresignExpires(expiry))
+	if core.IsErr(err, "cannot presign %s/%s: %v", s, name) {
+		return "", s.mapError(err)
+	}
+	return req.URL, nil
+}
+
 func (s *S3) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
+	prefix := strings.Trim(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
 	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
 
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(dir + "/"),
-		//		Prefix:    aws.String("ch.safepool/"),
+	var infos []fs.FileInfo
+	var found bool
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			logrus.Errorf("cannot list %s/%s: %v", s.String(), dir, err)
+			return nil, s.mapError(err)
+		}
+
+		for _, item := range result.CommonPrefixes {
+			found = true
+			name := strings.TrimRight((*item.Prefix)[len(prefix):], "/")
+			if opts&IncludeHiddenFiles == 0 && isHidden(name) {
+				continue
+			}
+
+			infos = append(infos, simpleFileInfo{
+				name:  name,
+				isDir: true,
+			})
+		}
+
+		for _, item := range result.Contents {
+			found = true
+			name := (*item.Key)[len(prefix):]
+			if name == "" || opts&IncludeHiddenFiles == 0 && isHidden(name) {
+				continue
+			}
+
+			infos = append(infos, simpleFileInfo{
+				name:    name,
+				size:    item.Size,
+				isDir:   false,
+				modTime: *item.LastModified,
+			})
+		}
+	}
+	if !found && prefix != "" {
+		return nil, fs.ErrNotExist
+	}
+
+	return infos, nil
+}
+
+// List returns a page of the entries in dir. Folders are included in the page where their first key is
+func (s *S3) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	prefix := strings.Trim(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix + filter.Prefix),
 		Delimiter: aws.String("/"),
 	}
+	if filter.StartAfter != "" {
+		input.StartAfter = aws.String(prefix + filter.StartAfter)
+	}
+	if filter.Limit > 0 {
+		input.MaxKeys = int32(filter.Limit)
+	}
+	if token != "" {
+		input.ContinuationToken = aws.String(token)
+	}
 
 	result, err := s.client.ListObjectsV2(context.TODO(), input)
 	if err != nil {
 		logrus.Errorf("cannot list %s/%s: %v", s.String(), dir, err)
-		return nil, s.mapError(err)
+		return nil, "", s.mapError(err)
 	}
 
 	var infos []fs.FileInfo
-
 	for _, item := range result.CommonPrefixes {
-		cut := len(path.Clean(dir))
-		name := strings.TrimRight((*item.Prefix)[cut+1:], "/")
-
-		infos = append(infos, simpleFileInfo{
-			name:  name,
-			isDir: true,
-		})
+		name := strings.TrimRight((*item.Prefix)[len(prefix):], "/")
+		if filter.match(name) {
+			infos = append(infos, simpleFileInfo{name: name, isDir: true})
+		}
 	}
-
 	for _, item := range result.Contents {
-		cut := len(path.Clean(dir))
-		name := (*item.Key)[cut+1:]
-
-		infos = append(infos, simpleFileInfo{
-			name:    name,
-			size:    item.Size,
-			isDir:   false,
-			modTime: *item.LastModified,
-		})
+		name := (*item.Key)[len(prefix):]
+		if name != "" && filter.match(name) {
+			infos = append(infos, simpleFileInfo{name: name, size: item.Size, modTime: *item.LastModified})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
 
+	if token == "" && filter.Prefix == "" && filter.StartAfter == "" && prefix != "" &&
+		len(result.CommonPrefixes) == 0 && len(result.Contents) == 0 {
+		return nil, "", fs.ErrNotExist
 	}
 
-	return infos, nil
+	var next string
+	if result.IsTruncated && result.NextContinuationToken != nil {
+		next = *result.NextContinuationToken
+	}
+	return infos, next, nil
 }
 
 func (s *S3) mapError(err error) error {
@@ -241,23 +425,36 @@ func (s *S3) Stat(name string) (fs.FileInfo, error) {
 }
 
 func (s *S3) Rename(old, new string) error {
+	source := url.URL{Path: path.Join(s.bucket, old)}
 	_, err := s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
 		Bucket:     &s.bucket,
-		CopySource: aws.String(url.QueryEscape(old)),
+		CopySource: aws.String(source.EscapedPath()),
 		Key:        aws.String(new),
 	})
+	if err != nil {
+		return s.mapError(err)
+	}
+
+	_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: &s.bucket,
+		Key:    &old,
+	})
 	return s.mapError(err)
 }
 
+// Delete removes the object with the provided name and all the objects in the folder with the same name
 func (s *S3) Delete(name string) error {
-	input := &s3.ListObjectsInput{
-		Bucket:    aws.String(s.bucket),
-		Prefix:    aws.String(name + "/"),
-		Delimiter: aws.String("/"),
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(name + "/"),
 	}
 
-	result, err := s.client.ListObjects(context.TODO(), input)
-	if err == nil && len(result.Contents) > 0 {
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(context.TODO())
+		if core.IsErr(err, "cannot list %s for delete: %v", name) {
+			return s.mapError(err)
+		}
 		for _, item := range result.Contents {
 			_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
 				Bucket: &s.bucket,
@@ -267,13 +464,12 @@ func (s *S3) Delete(name string) error {
 				return s.mapError(err)
 			}
 		}
-	} else {
-		_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: &s.bucket,
-			Key:    &name,
-		})
 	}
 
+	_, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: &s.bucket,
+		Key:    &name,
+	})
 	core.IsErr(err, "cannot delete %s: %v", name)
 	return s.mapError(err)
 }
@@ -0,0 +1,71 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+
+	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readSeeker struct {
+	io.Reader
+	io.Seeker
+}
+
+func TestS3Multipart(t *testing.T) {
+	require.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	require.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	var parts int32
+	url := startS3With(t, func(r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Query().Has("partNumber") {
+			atomic.AddInt32(&parts, 1)
+		}
+	})
+	_, err := storage.OpenStorage(url + "&partSize=4")
+	assert.ErrorIs(t, err, os.ErrInvalid, "parts smaller than 5MB must be refused")
+	s, err := storage.OpenStorage(url + "&partSize=5&parallel=2")
+	require.NoError(t, err)
+	defer s.Close()
+
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+	size := int64(len(data))
+
+	// the source breaks after the first part, as when the app is killed during the upload
+	broken := readSeeker{io.MultiReader(bytes.NewReader(data[0:6*1024*1024]), iotest.ErrReader(io.ErrUnexpectedEOF)), nil}
+	assert.Error(t, s.Write("big.bin", broken, size, nil))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&parts))
+	_, err = s.Stat("big.bin")
+	assert.Error(t, err)
+
+	progress := make(chan int64)
+	sum := make(chan int64)
+	go func() {
+		var total int64
+		for n := range progress {
+			total += n
+		}
+		sum <- total
+	}()
+	err = s.Write("big.bin", bytes.NewReader(data), size, progress)
+	close(progress)
+	require.NoError(t, err)
+	assert.Equal(t, size, <-sum)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&parts), "the second write must upload only the missing parts")
+
+	got, err := storage.ReadFile(s, "big.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/code-to-go/safepool/core"
+)
+
+// writeMultipart uploads source in parts of partSize bytes, with up to parallel parts in flight. The upload
+// id is saved in the local database, so that after a failure or a crash a new write of the same file
+// continues the pending upload. Parts already on the server are skipped when their MD5 matches the content
+func (s *S3) writeMultipart(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	uploadId, uploaded := s.resumeUpload(name, size)
+	if uploadId == "" {
+		out, err := s.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+			Bucket: &s.bucket,
+			Key:    &name,
+		})
+		if core.IsErr(err, "cannot create multipart upload for %s/%s: %v", s, name) {
+			return s.mapError(err)
+		}
+		uploadId = *out.UploadId
+		sqlSetUpload(s.url, name, uploadId, size, s.partSize)
+	}
+
+	count := (size + s.partSize - 1) / s.partSize
+	completed := make([]types.CompletedPart, count)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mutex.Unlock()
+	}
+
+	slots := make(chan bool, s.parallel)
+	for i := int64(0); i < count && !failed(); i++ {
+		partNumber := int32(i + 1)
+		slots <- true
+
+		buf := make([]byte, core.If(i == count-1, size-i*s.partSize, s.partSize))
+		if _, err := io.ReadFull(source, buf); core.IsErr(err, "cannot read part %d of %s: %v", partNumber, name) {
+			<-slots
+			fail(err)
+			break
+		}
+
+		sum := md5.Sum(buf)
+		if etag, ok := uploaded[partNumber]; ok && etag == hex.EncodeToString(sum[:]) {
+			core.Debug("part %d of %s/%s already uploaded", partNumber, s, name)
+			completed[i] = types.CompletedPart{PartNumber: partNumber, ETag: aws.String(`"` + etag + `"`)}
+			if progress != nil {
+				progress <- int64(len(buf))
+			}
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int64, buf []byte, sum [md5.Size]byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			out, err := s.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+				Bucket:        &s.bucket,
+				Key:           &name,
+				UploadId:      &uploadId,
+				PartNumber:    int32(i + 1),
+				Body:          bytes.NewReader(buf),
+				ContentLength: int64(len(buf)),
+				ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+			})
+			if core.IsErr(err, "cannot upload part %d of %s/%s: %v", i+1, s, name) {
+				fail(s.mapError(err))
+				return
+			}
+			completed[i] = types.CompletedPart{PartNumber: int32(i + 1), ETag: out.ETag}
+			if progress != nil {
+				progress <- int64(len(buf))
+			}
+		}(i, buf, sum)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	_, err := s.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             &name,
+		UploadId:        &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if core.IsErr(err, "cannot complete multipart upload of %s/%s: %v", s, name) {
+		return s.mapError(err)
+	}
+	sqlDelUpload(s.url, name)
+	return nil
+}
+
+// resumeUpload returns the id of a pending upload for name and the ETags of the parts already uploaded.
+// An upload with a different size or part size is aborted
+func (s *S3) resumeUpload(name string, size int64) (string, map[int32]string) {
+	uploadId, pendingSize, partSize, ok := sqlGetUpload(s.url, name)
+	if !ok {
+		return "", nil
+	}
+	if pendingSize != size || partSize != s.partSize {
+		s.abortUpload(name, uploadId)
+		return "", nil
+	}
+
+	uploaded := map[int32]string{}
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   &s.bucket,
+		Key:      &name,
+		UploadId: &uploadId,
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		var noUpload *types.NoSuchUpload
+		if errors.As(err, &noUpload) {
+			sqlDelUpload(s.url, name)
+			return "", nil
+		}
+		if core.IsErr(err, "cannot list parts of upload %s for %s/%s: %v", uploadId, s, name) {
+			return "", nil
+		}
+		for _, p := range out.Parts {
+			if p.ETag != nil {
+				uploaded[p.PartNumber] = strings.Trim(*p.ETag, "\"")
+			}
+		}
+	}
+	core.Info("resume upload of %s/%s with %d parts already uploaded", s, name, len(uploaded))
+	return uploadId, uploaded
+}
+
+func (s *S3) abortUpload(name string, uploadId string) {
+	_, err := s.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      &name,
+		UploadId: &uploadId,
+	})
+	core.IsErr(err, "cannot abort upload %s for %s/%s: %v", uploadId, s, name)
+	sqlDelUpload(s.url, name)
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/security"
+	"github.com/code-to-go/safepool/sql"
+)
+
+var ErrNoSecret = errors.New("secret reference cannot be resolved")
+
+// secretsNode is the configs node of the secret store
+const secretsNode = "secrets"
+
+// secretsIdentity encrypts the values in the secret store. It is set when the application starts
+var secretsIdentity *security.Identity
+
+// sensitiveParams are the url parameters whose values are hidden by Redact
+var sensitiveParams = []string{"secret", "password", "key", "token", "accountKey", "sas"}
+
+// SetSecretsIdentity sets the identity whose encryption key protects the secret store
+func SetSecretsIdentity(identity security.Identity) {
+	secretsIdentity = &identity
+}
+
+// SetSecret saves value in the secret store with the provided name. Connection urls refer to the value
+// with secret://name
+func SetSecret(name string, value string) error {
+	if !sql.IsOpen() || secretsIdentity == nil {
+		return core.ErrNotInitialized
+	}
+	data, err := security.EcEncrypt(*secretsIdentity, []byte(value))
+	if core.IsErr(err, "cannot encrypt secret %s: %v", name) {
+		return err
+	}
+	return sql.SetConfig(secretsNode, name, "", core.Now().Unix(), data)
+}
+
+// DelSecret removes a secret from the store
+func DelSecret(name string) error {
+	if !sql.IsOpen() {
+		return core.ErrNotInitialized
+	}
+	return sql.DelConfig(secretsNode, name)
+}
+
+// Secrets returns the names of the secrets in the store. Values are never returned
+func Secrets() ([]string, error) {
+	if !sql.IsOpen() {
+		return nil, core.ErrNotInitialized
+	}
+	return sql.GetConfigKeys(secretsNode)
+}
+
+func getSecret(name string) (string, error) {
+	if !sql.IsOpen() || secretsIdentity == nil {
+		return "", core.ErrNotInitialized
+	}
+	_, _, data, ok := sql.GetConfig(secretsNode, name)
+	if !ok {
+		return "", ErrNoSecret
+	}
+	value, err := security.EcDecrypt(*secretsIdentity, data)
+	if core.IsErr(err, "cannot decrypt secret %s: %v", name) {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// isSecretRef returns true when v is a reference to a secret in the store
+func isSecretRef(v string) bool {
+	return strings.HasPrefix(v, "secret://")
+}
+
+// resolveSecret returns the value of a reference secret://name from the secret store. Other values are
+// returned as they are
+func resolveSecret(v string) (string, error) {
+	if !isSecretRef(v) {
+		return v, nil
+	}
+	return getSecret(v[len("secret://"):])
+}
+
+// resolveSecrets replaces the secret references in the password and in the parameters of a connection url
+// with their values. The password must be percent-encoded, e.g. sftp://user:secret:%2F%2Fname@host. It must
+// be used only for urls configured on this device, since the values are sent to the host of the url
+func resolveSecrets(connectionUrl string) (string, error) {
+	u, err := url.Parse(connectionUrl)
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
+		return "", err
+	}
+
+	changed := false
+	if password, ok := u.User.Password(); ok && isSecretRef(password) {
+		password, err = resolveSecret(password)
+		if core.IsErr(err, "cannot resolve password in %s: %v", Redact(connectionUrl)) {
+			return "", err
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		changed = true
+	}
+
+	q := u.Query()
+	for k, vs := range q {
+		for i, v := range vs {
+			if !isSecretRef(v) {
+				continue
+			}
+			vs[i], err = resolveSecret(v)
+			if core.IsErr(err, "cannot resolve parameter %s in %s: %v", k, Redact(connectionUrl)) {
+				return "", err
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return connectionUrl, nil
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Redact hides the password and the values of sensitive parameters in a connection url. Secret references
+// are not hidden since they do not contain the value
+func Redact(connectionUrl string) string {
+	u, err := url.Parse(connectionUrl)
+	if err != nil {
+		return "<invalid url>"
+	}
+
+	changed := false
+	if password, ok := u.User.Password(); ok && !isSecretRef(password) {
+		u.User = url.UserPassword(u.User.Username(), "***")
+		changed = true
+	}
+
+	q := u.Query()
+	for _, k := range sensitiveParams {
+		for i, v := range q[k] {
+			if !isSecretRef(v) {
+				q[k][i] = "***"
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return connectionUrl
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,57 @@
+package storage_test
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/sql"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSFTPHostKey(t *testing.T) {
+	connectionUrl, fingerprint := startSFTP(t)
+	_, err := storage.OpenStorage(connectionUrl)
+	assert.True(t, errors.Is(err, core.ErrNotInitialized), "a key must not be trusted without a database, got %v", err)
+	s, err := storage.OpenStorage(connectionUrl + "?hostkey=" + url.QueryEscape(fingerprint))
+	require.NoError(t, err, "the key in the hostkey parameter must be accepted without a database")
+	s.Close()
+
+	require.NoError(t, sql.LoadSQLFromFile("../api/sqlite.sql"))
+	require.NoError(t, sql.OpenDB(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { sql.CloseDB() })
+
+	s, err = storage.OpenStorage(connectionUrl)
+	require.NoError(t, err)
+	s.Close()
+
+	keys, err := storage.HostKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	pinned := keys[0]
+	assert.Equal(t, "ssh-ed25519", pinned.Type)
+
+	s, err = storage.OpenStorage(connectionUrl)
+	require.NoError(t, err, "the pinned key must be accepted")
+	s.Close()
+
+	sql.SetConfig("sftp/hostkeys", pinned.Addr, "SHA256:changed", 0, nil)
+	_, err = storage.OpenStorage(connectionUrl)
+	assert.True(t, errors.Is(err, storage.ErrHostKeyMismatch), "a different key must be refused, got %v", err)
+
+	require.NoError(t, storage.ResetHostKey(pinned.Addr))
+	s, err = storage.OpenStorage(connectionUrl)
+	require.NoError(t, err, "a key must be pinned again after a reset")
+	s.Close()
+
+	s, err = storage.OpenStorage(connectionUrl + "?hostkey=" + url.QueryEscape(pinned.Fingerprint))
+	require.NoError(t, err, "the key in the hostkey parameter must be accepted")
+	s.Close()
+
+	_, err = storage.OpenStorage(connectionUrl + "?hostkey=SHA256:invalid")
+	assert.True(t, errors.Is(err, storage.ErrHostKeyMismatch), "a key different from the parameter must be refused")
+}
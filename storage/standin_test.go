@@ -0,0 +1,187 @@
+package storage_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+)
+
+// startSFTP runs an in-process SFTP server on a temporary folder and returns its connection url and the
+// fingerprint of its host key
+func startSFTP(t *testing.T) (string, string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("cannot create host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "test" && string(password) == "test" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied for %s", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return fmt.Sprintf("sftp://test:test@%s%s", l.Addr(), t.TempDir()), ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && strings.HasSuffix(string(req.Payload), "sftp"), nil)
+			}
+		}(requests)
+
+		server, err := sftp.NewServer(ch)
+		if err != nil {
+			ch.Close()
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// startWebDAV runs an in-memory WebDAV server and returns its connection url
+func startWebDAV(t *testing.T) string {
+	ts := httptest.NewServer(conditional(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}))
+	t.Cleanup(ts.Close)
+
+	return fmt.Sprintf("dav://%s/", ts.Listener.Addr())
+}
+
+// startS3 runs an in-memory S3 compatible server and returns its connection url
+func startS3(t *testing.T) string {
+	return startS3With(t, nil)
+}
+
+// startS3With is like startS3 and passes each request to observe before serving it
+func startS3With(t *testing.T, observe func(r *http.Request)) string {
+	faker := gofakes3.New(s3mem.New())
+	handler := conditional(faker.Server())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if observe != nil {
+			observe(r)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return fmt.Sprintf("s3://%s/safepool?accessKey=test&secret=test&region=us-east-1&tls=false&pathStyle=true",
+		ts.Listener.Addr())
+}
+
+// conditional adds support for the If-None-Match and If-Match headers on PUT requests, which are not
+// implemented by the stand-ins. The current ETag is read with a HEAD request and PUT requests are serialized
+func conditional(handler http.Handler) http.Handler {
+	var mutex sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		ifNoneMatch, ifMatch := r.Header.Get("If-None-Match"), r.Header.Get("If-Match")
+		if ifNoneMatch != "" || ifMatch != "" {
+			head := r.Clone(r.Context())
+			head.Method, head.Body, head.ContentLength = http.MethodHead, http.NoBody, 0
+			head.Header.Del("If-None-Match")
+			head.Header.Del("If-Match")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, head)
+
+			exists := rec.Code == http.StatusOK
+			if ifNoneMatch == "*" && exists || ifMatch != "" && (!exists || rec.Header().Get("ETag") != ifMatch) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// azuriteKey is the well-known shared key of the devstoreaccount1 account in the Azurite emulator
+const azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// startAzurite returns the connection url of the Azurite emulator at AZURITE_ENDPOINT, by default
+// http://127.0.0.1:10000/devstoreaccount1. The test is skipped when the emulator is not running
+func startAzurite(t *testing.T) string {
+	endpoint := os.Getenv("AZURITE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:10000/devstoreaccount1"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatalf("invalid AZURITE_ENDPOINT '%s': %v", endpoint, err)
+	}
+	c, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("Azurite is not running at %s: %v", endpoint, err)
+	}
+	c.Close()
+
+	container := "safepool" + strings.ReplaceAll(uuid.New().String(), "-", "")[:16]
+	return fmt.Sprintf("azblob://devstoreaccount1/%s?accountKey=%s&endpoint=%s", container,
+		url.QueryEscape(azuriteKey), url.QueryEscape(endpoint))
+}
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/code-to-go/safepool/core"
@@ -25,17 +29,39 @@ const (
 	IncludeHiddenFiles ListOption = 1
 )
 
+// isHidden returns true for names that are listed only with IncludeHiddenFiles
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// TempPrefix starts the names of files being written. Backends without atomic uploads write the content to a
+// hidden temporary file in the same folder and rename it when complete, so that readers never see partial files
+const TempPrefix = ".tmp-"
+
+// tempName returns a unique temporary name for the file name in the same folder
+func tempName(name string) string {
+	return fmt.Sprintf("%s%x-%s", TempPrefix, rand.Uint64(), name)
+}
+
+// IsTemp returns true when name is the temporary name of a file being written
+func IsTemp(name string) bool {
+	return strings.HasPrefix(path.Base(name), TempPrefix)
+}
+
 type Range struct {
 	From int64
 	To   int64
 }
 
-// Storage is a low level interface to storage services such as S3 or SFTP
+// Storage is a low level interface to storage services such as S3 or SFTP. When progress is not nil, Read and
+// Write send on it the number of bytes transferred since the previous report. Sends are blocking, so the caller
+// must consume the channel; the channel is not closed at the end of the transfer.
 type Storage interface {
 	// Read reads data from a file into a writer
 	Read(name string, rang *Range, dest io.Writer, progress chan int64) error
 
-	// Write writes data to a file name. An existing file is overwritten
+	// Write writes data to a file name. An existing file is overwritten. The write is atomic: readers see either
+	// the previous content or the complete new content
 	Write(name string, source io.ReadSeeker, size int64, progress chan int64) error
 
 	//ReadDir returns the entries of a folder content
@@ -53,24 +79,70 @@ type Storage interface {
 	// Close releases resources
 	Close() error
 
-	// String returns a human-readable representation of the storer (e.g. sftp://user@host.cc/path)
+	// String returns a human-readable representation of the storer (e.g. sftp://user@host.cc/path). Credentials
+	// are never included
 	String() string
 }
 
-// OpenStorage creates a new exchanger giving a provided configuration
+// OpenStorage creates a new exchanger giving a provided configuration. The driver is the one registered for
+// the scheme of the url. Wrappers registered with RegisterWrapper can prefix the scheme (e.g.
+// retry+s3://host/bucket). The chaos and retry parameters in the url also wrap the exchanger with a Chaos and
+// a Retry (e.g. s3://host/bucket?retry=5). Operations are recorded by a Metrics unless the url contains
+// metrics=false. The cache parameter keeps Stat and ReadDir results for a TTL (e.g. cache=1m,feeds=5s), see
+// ParseCacheConfig. Secret references in the url are not resolved, see OpenStorageTrusted
 func OpenStorage(connectionUrl string) (Storage, error) {
-	switch {
-	case strings.HasPrefix(connectionUrl, "sftp://"):
-		return OpenSFTP(connectionUrl)
-	case strings.HasPrefix(connectionUrl, "s3://"):
-		return OpenS3(connectionUrl)
-	case strings.HasPrefix(connectionUrl, "file:/"):
-		return OpenLocal(connectionUrl)
-	case strings.HasPrefix(connectionUrl, "dav://"):
-		return OpenWebDAV(connectionUrl)
-	case strings.HasPrefix(connectionUrl, "davs://"):
-		return OpenWebDAV(connectionUrl)
+	names, scheme, driverUrl := splitScheme(connectionUrl)
+	opener, ws, err := lookup(scheme, names)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, core.ErrNoDriver
+	u, err := url.Parse(driverUrl)
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
+		return nil, err
+	}
+
+	s, err := opener(driverUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// the wrappers in the parameters are the closest to the driver unless they are in the scheme
+	explicit := map[string]bool{}
+	for _, n := range names {
+		explicit[n] = true
+	}
+	var chain []Wrapper
+	for _, w := range []struct {
+		name string
+		wrap Wrapper
+	}{{"metrics", metricsFromUrl}, {"chaos", chaosFromUrl}, {"retry", retryFromUrl}, {"cache", cacheFromUrl}} {
+		if !explicit[w.name] {
+			chain = append(chain, w.wrap)
+		}
+	}
+	for i := len(ws) - 1; i >= 0; i-- {
+		chain = append(chain, ws[i])
+	}
+
+	for _, wrap := range chain {
+		w, err := wrap(s, u.Query())
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = w
+	}
+	return s, nil
+}
+
+// OpenStorageTrusted is like OpenStorage and replaces the references secret://name in the password and in the
+// parameters with the values in the secret store. Use it only for urls configured on this device: a url from
+// an invite could send the local secrets to any host
+func OpenStorageTrusted(connectionUrl string) (Storage, error) {
+	connectionUrl, err := resolveSecrets(connectionUrl)
+	if err != nil {
+		return nil, err
+	}
+	return OpenStorage(connectionUrl)
 }
@@ -0,0 +1,383 @@
+// Package storagetest provides a conformance suite for storage.Storage implementations. A backend test
+// calls Run with a function that opens a fresh connection to a local stand-in of the service.
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+	"github.com/code-to-go/safepool/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// OpenFunc returns a storage under test. It is called once for each test in the suite
+type OpenFunc func() (storage.Storage, error)
+
+var content = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+// Run checks that the storage returned by open behaves as expected by the pool. Each test works in
+// its own random folder so that the suite can run against a shared service
+func Run(t *testing.T, open OpenFunc) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, s storage.Storage, dir string)
+	}{
+		{"WriteRead", testWriteRead},
+		{"Overwrite", testOverwrite},
+		{"Range", testRange},
+		{"ReadDir", testReadDir},
+		{"HiddenFiles", testHiddenFiles},
+		{"Stat", testStat},
+		{"StatMissing", testStatMissing},
+		{"Rename", testRename},
+		{"RenameOverwrite", testRenameOverwrite},
+		{"Delete", testDelete},
+		{"DeleteRecursive", testDeleteRecursive},
+		{"Progress", testProgress},
+		{"AtomicWrite", testAtomicWrite},
+		{"List", testList},
+		{"ConditionalWrite", testConditionalWrite},
+		{"Lease", testLease},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := open()
+			require.NoErrorf(t, err, "cannot open storage: %v", err)
+			defer s.Close()
+
+			dir := uuid.New().String()
+			defer s.Delete(dir)
+			tc.f(t, s, dir)
+		})
+	}
+}
+
+func write(t *testing.T, s storage.Storage, name string, data []byte) {
+	err := storage.WriteFile(s, name, data)
+	require.NoErrorf(t, err, "cannot write %s: %v", name, err)
+}
+
+func read(t *testing.T, s storage.Storage, name string, rang *storage.Range) []byte {
+	var b bytes.Buffer
+	err := s.Read(name, rang, &b, nil)
+	require.NoErrorf(t, err, "cannot read %s: %v", name, err)
+	return b.Bytes()
+}
+
+func names(t *testing.T, s storage.Storage, dir string, opts storage.ListOption) []string {
+	ls, err := s.ReadDir(dir, opts)
+	require.NoErrorf(t, err, "cannot list %s: %v", dir, err)
+
+	var ns []string
+	for _, l := range ls {
+		ns = append(ns, l.Name())
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+func testWriteRead(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "a.txt")
+	write(t, s, name, content)
+	assert.Equal(t, content, read(t, s, name, nil))
+
+	var b bytes.Buffer
+	err := s.Read(path.Join(dir, "missing.txt"), nil, &b, nil)
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "read of missing file must return os.ErrNotExist, got %v", err)
+}
+
+func testOverwrite(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "a.txt")
+	write(t, s, name, content)
+	write(t, s, name, content[0:5])
+	assert.Equal(t, content[0:5], read(t, s, name, nil))
+
+	stat, err := s.Stat(name)
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, stat.Size())
+}
+
+func testRange(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "a.txt")
+	write(t, s, name, content)
+
+	assert.Equal(t, content[0:4], read(t, s, name, &storage.Range{From: 0, To: 4}))
+	assert.Equal(t, content[10:20], read(t, s, name, &storage.Range{From: 10, To: 20}))
+	assert.Equal(t, content[30:], read(t, s, name, &storage.Range{From: 30, To: int64(len(content))}))
+	assert.Equal(t, content[30:], read(t, s, name, &storage.Range{From: 30, To: int64(len(content)) + 100}))
+}
+
+func testReadDir(t *testing.T, s storage.Storage, dir string) {
+	write(t, s, path.Join(dir, "a.txt"), content)
+	write(t, s, path.Join(dir, "sub", "b.txt"), content)
+	write(t, s, path.Join(dir, "sub", "deep", "c.txt"), content)
+
+	ls, err := s.ReadDir(dir, 0)
+	require.NoError(t, err)
+	require.Len(t, ls, 2)
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Name() < ls[j].Name() })
+	assert.Equal(t, "a.txt", ls[0].Name())
+	assert.False(t, ls[0].IsDir())
+	assert.EqualValues(t, len(content), ls[0].Size())
+	assert.Equal(t, "sub", ls[1].Name())
+	assert.True(t, ls[1].IsDir())
+
+	assert.Equal(t, []string{"b.txt", "deep"}, names(t, s, path.Join(dir, "sub"), 0))
+	assert.Equal(t, []string{"c.txt"}, names(t, s, path.Join(dir, "sub", "deep"), 0))
+
+	_, err = s.ReadDir(path.Join(dir, "missing"), 0)
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "list of missing folder must return os.ErrNotExist, got %v", err)
+}
+
+func testHiddenFiles(t *testing.T, s storage.Storage, dir string) {
+	write(t, s, path.Join(dir, "a.txt"), content)
+	write(t, s, path.Join(dir, ".touch"), nil)
+
+	assert.Equal(t, []string{"a.txt"}, names(t, s, dir, 0))
+	assert.Equal(t, []string{".touch", "a.txt"}, names(t, s, dir, storage.IncludeHiddenFiles))
+}
+
+func testStat(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "a.txt")
+	write(t, s, name, content)
+
+	stat, err := s.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", stat.Name())
+	assert.EqualValues(t, len(content), stat.Size())
+	assert.False(t, stat.IsDir())
+	assert.False(t, stat.ModTime().IsZero())
+}
+
+func testStatMissing(t *testing.T, s storage.Storage, dir string) {
+	_, err := s.Stat(path.Join(dir, "missing.txt"))
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "stat of missing file must return os.ErrNotExist, got %v", err)
+}
+
+func testRename(t *testing.T, s storage.Storage, dir string) {
+	a, b := path.Join(dir, "a.txt"), path.Join(dir, "b.txt")
+	write(t, s, a, content)
+
+	require.NoError(t, s.Rename(a, b))
+	assert.Equal(t, content, read(t, s, b, nil))
+	_, err := s.Stat(a)
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "renamed file must not exist, got %v", err)
+}
+
+func testRenameOverwrite(t *testing.T, s storage.Storage, dir string) {
+	a, b := path.Join(dir, "a.txt"), path.Join(dir, "b.txt")
+	write(t, s, a, content)
+	write(t, s, b, content[0:3])
+
+	require.NoError(t, s.Rename(a, b))
+	assert.Equal(t, content, read(t, s, b, nil))
+	_, err := s.Stat(a)
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "renamed file must not exist, got %v", err)
+}
+
+func testDelete(t *testing.T, s storage.Storage, dir string) {
+	a, b := path.Join(dir, "a.txt"), path.Join(dir, "b.txt")
+	write(t, s, a, content)
+	write(t, s, b, content)
+
+	require.NoError(t, s.Delete(a))
+	_, err := s.Stat(a)
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "deleted file must not exist, got %v", err)
+	assert.Equal(t, []string{"b.txt"}, names(t, s, dir, 0))
+}
+
+func testDeleteRecursive(t *testing.T, s storage.Storage, dir string) {
+	write(t, s, path.Join(dir, "a.txt"), content)
+	write(t, s, path.Join(dir, "sub", "b.txt"), content)
+	write(t, s, path.Join(dir, "sub", "deep", "c.txt"), content)
+	write(t, s, path.Join(dir, "sub", ".touch"), nil)
+
+	require.NoError(t, s.Delete(path.Join(dir, "sub")))
+	_, err := s.Stat(path.Join(dir, "sub", "deep", "c.txt"))
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "deleted file must not exist, got %v", err)
+	assert.Equal(t, []string{"a.txt"}, names(t, s, dir, storage.IncludeHiddenFiles))
+}
+
+// transferred consumes a progress channel and returns the sum of the reports when the channel is closed
+func transferred(t *testing.T, progress chan int64) chan int64 {
+	sum := make(chan int64)
+	go func() {
+		var total int64
+		for n := range progress {
+			assert.Positive(t, n, "progress reports must be positive")
+			total += n
+		}
+		sum <- total
+	}()
+	return sum
+}
+
+func testProgress(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "large.bin")
+	data := bytes.Repeat(content, 16*1024)
+	size := int64(len(data))
+
+	// retries in wrappers may transfer some bytes more than once
+	progress := make(chan int64)
+	sum := transferred(t, progress)
+	err := s.Write(name, bytes.NewReader(data), size, progress)
+	close(progress)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, <-sum, size, "write progress must cover the whole content")
+
+	var b bytes.Buffer
+	progress = make(chan int64)
+	sum = transferred(t, progress)
+	err = s.Read(name, nil, &b, progress)
+	close(progress)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, <-sum, size, "read progress must cover the whole content")
+	assert.Equal(t, data, b.Bytes())
+
+	progress = make(chan int64)
+	sum = transferred(t, progress)
+	err = s.Read(name, &storage.Range{From: 10, To: 1010}, &b, progress)
+	close(progress)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, <-sum, int64(1000), "read progress must cover the range")
+}
+
+// failingReader returns an error after the first half of the content
+type failingReader struct {
+	r    *bytes.Reader
+	half int64
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	pos := f.r.Size() - int64(f.r.Len())
+	if pos >= f.half {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > f.half-pos {
+		p = p[0 : f.half-pos]
+	}
+	return f.r.Read(p)
+}
+
+func (f *failingReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("not seekable")
+}
+
+func testAtomicWrite(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "a.txt")
+	write(t, s, name, content)
+	for _, n := range names(t, s, dir, storage.IncludeHiddenFiles) {
+		assert.Falsef(t, storage.IsTemp(n), "temporary file %s left after write", n)
+	}
+
+	data := bytes.Repeat(content, 1024)
+	r := &failingReader{bytes.NewReader(data), int64(len(data) / 2)}
+	err := s.Write(name, r, int64(len(data)), nil)
+	assert.Error(t, err)
+	assert.Equal(t, content, read(t, s, name, nil), "a failed write must keep the previous content")
+	for _, n := range names(t, s, dir, storage.IncludeHiddenFiles) {
+		assert.Falsef(t, storage.IsTemp(n), "temporary file %s left after failed write", n)
+	}
+}
+
+func testList(t *testing.T, s storage.Storage, dir string) {
+	for _, n := range []string{"1.head", "1.body", "2.head", "2.body", "3.head", ".touch"} {
+		write(t, s, path.Join(dir, n), content)
+	}
+	write(t, s, path.Join(dir, "sub", "a.txt"), content)
+
+	list := func(filter storage.ListFilter) []string {
+		var ns []string
+		it := storage.NewDirIterator(s, dir, filter)
+		for it.Next() {
+			ns = append(ns, it.Info().Name())
+		}
+		require.NoErrorf(t, it.Err(), "cannot list %s: %v", dir, it.Err())
+		return ns
+	}
+
+	assert.Equal(t, []string{"1.body", "1.head", "2.body", "2.head", "3.head", "sub"}, list(storage.ListFilter{}))
+	assert.Equal(t, []string{"1.body", "1.head", "2.body", "2.head", "3.head", "sub"}, list(storage.ListFilter{Limit: 2}))
+	assert.Equal(t, []string{"2.body", "2.head", "3.head", "sub"}, list(storage.ListFilter{StartAfter: "1.head"}))
+	assert.Equal(t, []string{"2.body", "2.head"}, list(storage.ListFilter{Prefix: "2.", Limit: 1}))
+	assert.Equal(t, []string{".touch"}, list(storage.ListFilter{Prefix: ".", Options: storage.IncludeHiddenFiles}))
+	assert.Empty(t, list(storage.ListFilter{Prefix: "."}))
+
+	_, _, err := storage.ListPage(s, path.Join(dir, "missing"), storage.ListFilter{}, "")
+	assert.Truef(t, errors.Is(err, os.ErrNotExist), "list of missing folder must return os.ErrNotExist, got %v", err)
+}
+
+func writeIf(s storage.Storage, name string, data []byte, cond storage.Condition) (string, error) {
+	return storage.WriteIf(s, name, core.NewBytesReader(data), int64(len(data)), cond)
+}
+
+func testConditionalWrite(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, "lock")
+	etag, err := writeIf(s, name, content, storage.Condition{IfNoneMatch: true})
+	if errors.Is(err, storage.ErrNotSupported) {
+		t.Skip("conditional writes are not supported")
+	}
+	require.NoError(t, err)
+
+	_, err = writeIf(s, name, content, storage.Condition{IfNoneMatch: true})
+	assert.Truef(t, errors.Is(err, storage.ErrPreconditionFailed), "create of existing file must fail, got %v", err)
+
+	var b bytes.Buffer
+	current, err := storage.ReadWithETag(s, name, &b)
+	require.NoError(t, err)
+	assert.Equal(t, content, b.Bytes())
+	if etag != "" {
+		assert.Equal(t, etag, current)
+	}
+
+	next, err := writeIf(s, name, content[0:5], storage.Condition{IfMatch: current})
+	require.NoError(t, err)
+	assert.NotEqual(t, current, next)
+	assert.Equal(t, content[0:5], read(t, s, name, nil))
+
+	_, err = writeIf(s, name, content, storage.Condition{IfMatch: current})
+	assert.Truef(t, errors.Is(err, storage.ErrPreconditionFailed), "write with stale etag must fail, got %v", err)
+	assert.Equal(t, content[0:5], read(t, s, name, nil))
+
+	_, err = writeIf(s, path.Join(dir, "missing"), content, storage.Condition{IfMatch: current})
+	assert.Truef(t, errors.Is(err, storage.ErrPreconditionFailed), "write on missing file must fail, got %v", err)
+
+	ls, err := s.ReadDir(dir, storage.IncludeHiddenFiles)
+	require.NoError(t, err)
+	assert.Len(t, ls, 1, "no temporary files must remain")
+}
+
+func testLease(t *testing.T, s storage.Storage, dir string) {
+	name := path.Join(dir, ".lock")
+	l, err := storage.AcquireLease(s, name, time.Minute)
+	if errors.Is(err, storage.ErrNotSupported) {
+		t.Skip("conditional writes are not supported")
+	}
+	require.NoError(t, err)
+
+	_, err = storage.AcquireLease(s, name, time.Minute)
+	assert.Equal(t, storage.ErrLocked, err)
+	require.NoError(t, l.Renew())
+	require.NoError(t, l.Release())
+
+	other, err := storage.WaitLease(s, name, 100*time.Millisecond, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, storage.ErrLeaseLost, l.Renew())
+
+	// an expired lease can be acquired by another owner
+	time.Sleep(200 * time.Millisecond)
+	l, err = storage.WaitLease(s, name, time.Minute, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, storage.ErrLeaseLost, other.Renew())
+	assert.NoError(t, l.Release())
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// Limiter is a token bucket that limits the bytes transferred per second. The bucket holds at most one second
+// of traffic, so short bursts are not delayed. A Limiter can be shared by many storages and goroutines
+type Limiter struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter with the provided rate in bytes per second. A rate of 0 means no limit
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// SetRate changes the rate in bytes per second. A rate of 0 means no limit
+func (l *Limiter) SetRate(rate int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate <= 0 {
+		l.tokens, l.last = float64(rate), time.Now()
+	} else if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.rate = rate
+}
+
+// Rate returns the rate in bytes per second
+func (l *Limiter) Rate() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate
+}
+
+// Wait blocks until n bytes can be transferred. The tokens are reserved before waiting, so concurrent
+// callers are served in order
+func (l *Limiter) Wait(n int) {
+	l.mutex.Lock()
+	if l.rate <= 0 {
+		l.mutex.Unlock()
+		return
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mutex.Unlock()
+	time.Sleep(d)
+}
+
+// Throttle wraps a storage and limits the throughput of reads and writes with a Limiter. Other operations
+// are not limited
+type Throttle struct {
+	s Storage
+	l *Limiter
+}
+
+// NewThrottle wraps s so that reads and writes do not exceed the rate of l
+func NewThrottle(s Storage, l *Limiter) Storage {
+	return &Throttle{s, l}
+}
+
+// wrapThrottle wraps s with a limiter whose rate in bytes per second is the rate parameter of the url (e.g.
+// throttle+s3://host/bucket?rate=65536). A missing rate means no limit
+func wrapThrottle(s Storage, query map[string][]string) (Storage, error) {
+	var rate int64
+	var err error
+	if v := queryValue(query, "rate"); v != "" {
+		rate, err = strconv.ParseInt(v, 10, 64)
+		if core.IsErr(err, "invalid rate parameter '%s': %v", v) {
+			return nil, err
+		}
+	}
+	return NewThrottle(s, NewLimiter(rate)), nil
+}
+
+type throttledWriter struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	t.l.Wait(len(p))
+	return t.w.Write(p)
+}
+
+type throttledReadSeeker struct {
+	io.ReadSeeker
+	l *Limiter
+}
+
+func (t throttledReadSeeker) Read(p []byte) (int, error) {
+	n, err := t.ReadSeeker.Read(p)
+	t.l.Wait(n)
+	return n, err
+}
+
+func (t *Throttle) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
+	return t.s.Read(name, rang, throttledWriter{dest, t.l}, progress)
+}
+
+func (t *Throttle) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
+	return t.s.Write(name, throttledReadSeeker{source, t.l}, size, progress)
+}
+
+func (t *Throttle) ReadWithETag(name string, dest io.Writer) (string, error) {
+	return ReadWithETag(t.s, name, throttledWriter{dest, t.l})
+}
+
+func (t *Throttle) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	return WriteIf(t.s, name, throttledReadSeeker{source, t.l}, size, cond)
+}
+
+func (t *Throttle) ReadDir(name string, opts ListOption) ([]fs.FileInfo, error) {
+	return t.s.ReadDir(name, opts)
+}
+
+func (t *Throttle) List(dir string, filter ListFilter, token string) ([]fs.FileInfo, string, error) {
+	return ListPage(t.s, dir, filter, token)
+}
+
+func (t *Throttle) Stat(name string) (os.FileInfo, error) {
+	return t.s.Stat(name)
+}
+
+func (t *Throttle) Rename(old, new string) error {
+	return t.s.Rename(old, new)
+}
+
+func (t *Throttle) Delete(name string) error {
+	return t.s.Delete(name)
+}
+
+func (t *Throttle) Watch(dir string, stop chan struct{}) (chan string, error) {
+	return watchInner(t.s, dir, stop)
+}
+
+func (t *Throttle) Presign(name string, expiry time.Duration) (string, error) {
+	return Presign(t.s, name, expiry)
+}
+
+func (t *Throttle) ReadOnly() bool {
+	return IsReadOnly(t.s)
+}
+
+func (t *Throttle) Close() error {
+	return t.s.Close()
+}
+
+func (t *Throttle) String() string {
+	return t.s.String()
+}
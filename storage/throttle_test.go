@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	m, err := OpenStorage("mem://" + uuid.New().String())
+	require.NoError(t, err)
+	defer m.Close()
+
+	l := NewLimiter(100 * 1024)
+	s := NewThrottle(m, l)
+	data := make([]byte, 150*1024)
+
+	// the first 100KB are the burst, the other 50KB take half a second
+	start := time.Now()
+	require.NoError(t, WriteFile(s, "a.bin", data))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+
+	// reads share the limiter, which is now empty
+	start = time.Now()
+	got, err := ReadFile(s, "a.bin")
+	require.NoError(t, err)
+	assert.Len(t, got, len(data))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	l.SetRate(0)
+	start = time.Now()
+	require.NoError(t, WriteFile(s, "a.bin", data))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"path"
+	"time"
+
+	"github.com/code-to-go/safepool/core"
+)
+
+// GuardFile is the hidden file that writers update after a change in a folder. Storages without change
+// notifications are polled on the guard file
+const GuardFile = ".touch"
+
+// MinPollInterval and MaxPollInterval limit the period of polling. The period doubles at every poll without
+// changes and returns to MinPollInterval after a change
+var MinPollInterval = 2 * time.Second
+var MaxPollInterval = time.Minute
+
+// Watcher is implemented by storages that push change notifications
+type Watcher interface {
+	// Watch sends the names of the files created or changed in dir and its subfolders until stop is closed.
+	// A change may be notified more than once. The returned channel is closed when the watch ends
+	Watch(dir string, stop chan struct{}) (chan string, error)
+}
+
+// Watch notifies the changes in dir until stop is closed. Storages that are not a Watcher are polled on the
+// guard file of dir, so only the changes followed by an update of the guard file are notified
+func Watch(s Storage, dir string, stop chan struct{}) (chan string, error) {
+	if w, ok := s.(Watcher); ok {
+		ch, err := w.Watch(dir, stop)
+		if err != ErrNotSupported {
+			return ch, err
+		}
+	}
+	return poll(s, dir, stop), nil
+}
+
+// watchInner is used by wrappers to forward a watch to a storage that is a Watcher. It returns
+// ErrNotSupported otherwise, so that Watch polls through the wrapper
+func watchInner(s Storage, dir string, stop chan struct{}) (chan string, error) {
+	if w, ok := s.(Watcher); ok {
+		return w.Watch(dir, stop)
+	}
+	return nil, ErrNotSupported
+}
+
+// poll checks the modification time of the guard file of dir with an adaptive period
+func poll(s Storage, dir string, stop chan struct{}) chan string {
+	guard := path.Join(dir, GuardFile)
+	ch := make(chan string)
+	min, max := MinPollInterval, MaxPollInterval
+
+	go func() {
+		defer close(ch)
+
+		var last time.Time
+		if stat, err := s.Stat(guard); err == nil {
+			last = stat.ModTime()
+		}
+
+		interval := min
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(interval):
+			}
+
+			stat, err := s.Stat(guard)
+			if err != nil || !stat.ModTime().After(last) {
+				interval *= 2
+				if interval > max {
+					interval = max
+				}
+				continue
+			}
+
+			core.Debug("guard file %s in %s changed", guard, s)
+			last, interval = stat.ModTime(), min
+			select {
+			case ch <- guard:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return ch
+}
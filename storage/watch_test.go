@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, ch chan string) string {
+	select {
+	case name := <-ch:
+		return name
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change notified")
+		return ""
+	}
+}
+
+func TestWatchLocal(t *testing.T) {
+	s, err := OpenStorage("file://" + t.TempDir() + "?retry=2")
+	require.NoError(t, err)
+	defer s.Close()
+
+	stop := make(chan struct{})
+	ch, err := Watch(s, "pool/feeds", stop)
+	require.NoError(t, err)
+
+	require.NoError(t, WriteFile(s, "pool/feeds/20230101/1.head", []byte("head")))
+	assert.Equal(t, "pool/feeds/20230101", nextEvent(t, ch))
+	assert.Equal(t, "pool/feeds/20230101/1.head", nextEvent(t, ch))
+
+	close(stop)
+	for range ch {
+	}
+}
+
+func TestWatchPolling(t *testing.T) {
+	MinPollInterval = 10 * time.Millisecond
+	defer func() { MinPollInterval = 2 * time.Second }()
+
+	s, err := OpenStorage("mem://" + uuid.New().String())
+	require.NoError(t, err)
+	defer s.Close()
+
+	stop := make(chan struct{})
+	ch, err := Watch(s, "pool/feeds", stop)
+	require.NoError(t, err)
+
+	require.NoError(t, WriteFile(s, "pool/feeds/20230101/1.head", []byte("head")))
+	time.Sleep(5 * MinPollInterval)
+	require.NoError(t, WriteFile(s, "pool/feeds/"+GuardFile, nil))
+	assert.Equal(t, "pool/feeds/"+GuardFile, nextEvent(t, ch))
+
+	close(stop)
+	for range ch {
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -13,23 +14,27 @@ import (
 )
 
 type WebDAV struct {
-	c   *gowebdav.Client
-	p   string
-	url string
+	c        *gowebdav.Client
+	p        string
+	url      string
+	conn     string
+	user     string
+	password string
+	client   *http.Client
 }
 
 func OpenWebDAV(connectionUrl string) (Storage, error) {
 	u, err := url.Parse(connectionUrl)
-	if core.IsErr(err, "invalid url '%s': %v", connectionUrl) {
+	if core.IsErr(err, "invalid url '%s': %v", Redact(connectionUrl)) {
 		return nil, err
 	}
 
 	var conn string
 	switch u.Scheme {
 	case "dav":
-		conn = fmt.Sprintf("http://%s:%s/%s", u.Host, u.Port(), u.Path)
+		conn = fmt.Sprintf("http://%s", u.Host)
 	case "davs":
-		conn = fmt.Sprintf("https://%s:%s/%s", u.Host, u.Port(), u.Path)
+		conn = fmt.Sprintf("https://%s", u.Host)
 	default:
 		core.IsErr(os.ErrInvalid, "invalid scheme %s: %v", u.Scheme)
 		return nil, os.ErrInvalid
@@ -37,15 +42,21 @@ func OpenWebDAV(connectionUrl string) (Storage, error) {
 
 	password, _ := u.User.Password()
 	c := gowebdav.NewClient(conn, u.User.Username(), password)
+	client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	c.SetTransport(client.Transport)
 	err = c.Connect()
-	if core.IsErr(err, "cannot connect to WebDAV '%s': %v", connectionUrl) {
+	if core.IsErr(err, "cannot connect to WebDAV '%s': %v", Redact(connectionUrl)) {
 		return nil, err
 	}
 
 	w := &WebDAV{
-		c:   c,
-		p:   u.Path,
-		url: connectionUrl,
+		c:        c,
+		p:        u.Path,
+		url:      Redact(connectionUrl),
+		conn:     conn,
+		user:     u.User.Username(),
+		password: password,
+		client:   client,
 	}
 
 	return w, nil
@@ -54,75 +65,174 @@ func OpenWebDAV(connectionUrl string) (Storage, error) {
 func (w *WebDAV) Read(name string, rang *Range, dest io.Writer, progress chan int64) error {
 	p := path.Join(w.p, name)
 
-	r, err := w.c.ReadStream(p)
+	var r io.ReadCloser
+	var err error
+	if rang == nil {
+		r, err = w.c.ReadStream(p)
+	} else {
+		r, err = w.c.ReadStreamRange(p, rang.From, rang.To-rang.From)
+	}
+	if gowebdav.IsErrNotFound(err) {
+		return os.ErrNotExist
+	}
 	if core.IsErr(err, "cannot read WebDAV file %s: %v", p) {
 		return err
 	}
+	defer r.Close()
 
-	var written int64
-	if rang == nil {
-		for err == nil {
-			written, err = io.CopyN(dest, r, 1024*1024)
-			if progress != nil {
-				progress <- written
-			}
-		}
-	} else {
-		written, err = io.CopyN(io.Discard, r, rang.From)
-		if core.IsErr(err, "cannot discard %n bytes in range GET on %s: %v", rang.From, p) {
-			return err
-		}
-		if written != rang.From {
-			core.IsErr(io.ErrShortWrite, "Cannot read %d bytes in GET %s: %v")
-			return io.ErrShortWrite
-		}
+	pw := newProgressWriter(dest, progress)
+	defer pw.flush()
 
-		written, err = io.CopyN(dest, r, rang.To-rang.From)
-		if progress != nil {
-			progress <- written
-		}
-	}
-	if err != nil && err != io.EOF {
-		core.IsErr(err, "cannot read from GET response on %s: %v", p)
+	_, err = io.Copy(pw, r)
+	if core.IsErr(err, "cannot read from GET response on %s: %v", p) {
 		return err
 	}
-	r.Close()
 	return nil
 }
 
 func (w *WebDAV) Write(name string, source io.ReadSeeker, size int64, progress chan int64) error {
 	p := path.Join(w.p, name)
 
-	err := w.c.WriteStream(p, source, 0)
+	tmp := path.Join(path.Dir(p), tempName(path.Base(p)))
+
+	pr := newProgressReader(source, progress)
+	defer pr.flush()
+
+	err := w.c.WriteStream(tmp, pr, 0)
+	if err == nil {
+		err = w.c.Rename(tmp, p, true)
+	}
 	if core.IsErr(err, "cannot write WebDAV file %s: %v", p) {
+		w.c.Remove(tmp)
 		return err
 	}
 
 	return nil
 }
 
+// request sends a request to the server outside the WebDAV client, which does not support custom headers. It
+// shares the transport of the WebDAV client
+func (w *WebDAV) request(method, name string, body io.Reader, header map[string]string) (*http.Response, error) {
+	u := url.URL{Path: path.Join("/", w.p, name)}
+	req, err := http.NewRequest(method, w.conn+u.EscapedPath(), body)
+	if err != nil {
+		return nil, err
+	}
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.password)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	return w.client.Do(req)
+}
+
+// ReadWithETag reads a file with a GET request. It returns ErrNotSupported when the server does not send ETags
+func (w *WebDAV) ReadWithETag(name string, dest io.Writer) (string, error) {
+	resp, err := w.request(http.MethodGet, name, nil, nil)
+	if core.IsErr(err, "cannot read WebDAV file %s: %v", name) {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	default:
+		return "", gowebdav.StatusError{Status: resp.StatusCode}
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", ErrNotSupported
+	}
+
+	_, err = io.Copy(dest, resp.Body)
+	if core.IsErr(err, "cannot read from GET response on %s: %v", name) {
+		return "", err
+	}
+	return etag, nil
+}
+
+// WriteIf sends a PUT request with the If-None-Match or the If-Match header. When the response has no ETag, the
+// ETag is read from the properties of the file; it returns ErrNotSupported when the server does not keep ETags
+func (w *WebDAV) WriteIf(name string, source io.ReadSeeker, size int64, cond Condition) (string, error) {
+	header := map[string]string{}
+	if cond.IfNoneMatch {
+		header["If-None-Match"] = "*"
+	}
+	if cond.IfMatch != "" {
+		header["If-Match"] = cond.IfMatch
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := w.request(http.MethodPut, name, source, header)
+		if core.IsErr(err, "cannot write WebDAV file %s: %v", name) {
+			return "", err
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusCreated,
+			resp.StatusCode == http.StatusNoContent:
+			return w.etag(name, resp)
+		case resp.StatusCode == http.StatusPreconditionFailed,
+			resp.StatusCode == http.StatusNotFound && cond.IfMatch != "":
+			return "", &fs.PathError{Op: "write", Path: name, Err: ErrPreconditionFailed}
+		case (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) && attempt == 0:
+			// the parent folder is missing
+			w.c.MkdirAll(path.Join(w.p, path.Dir(name)), 0755)
+			if _, err := source.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+		default:
+			return "", gowebdav.StatusError{Status: resp.StatusCode}
+		}
+	}
+}
+
+func (w *WebDAV) etag(name string, resp *http.Response) (string, error) {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	info, err := w.c.Stat(path.Join(w.p, name))
+	if core.IsErr(err, "cannot stat WebDAV file %s: %v", name) {
+		return "", err
+	}
+	if f, ok := info.(*gowebdav.File); ok && f.ETag() != "" {
+		return f.ETag(), nil
+	}
+	return "", ErrNotSupported
+}
+
 func (w *WebDAV) ReadDir(dir string, opts ListOption) ([]fs.FileInfo, error) {
 	p := path.Join(w.p, dir)
 
 	ls, err := w.c.ReadDir(p)
-	if _, ok := err.(*fs.PathError); ok {
+	if gowebdav.IsErrNotFound(err) {
 		return nil, os.ErrNotExist
 	}
 	if core.IsErr(err, "cannot read WebDAV folder %s: %v", p) {
 		return nil, err
 	}
-	return ls, err
+
+	var infos []fs.FileInfo
+	for _, l := range ls {
+		if opts&IncludeHiddenFiles != 0 || !isHidden(l.Name()) {
+			infos = append(infos, l)
+		}
+	}
+	return infos, nil
 }
 
 func (w *WebDAV) Stat(name string) (fs.FileInfo, error) {
 	p := path.Join(w.p, name)
 
 	f, err := w.c.Stat(p)
-	if _, ok := err.(*fs.PathError); ok {
+	if gowebdav.IsErrNotFound(err) {
 		return nil, os.ErrNotExist
 	}
-
-	if core.IsErr(err, "cannot read WebDAV folder %s: %v", p) {
+	if core.IsErr(err, "cannot stat WebDAV file %s: %v", p) {
 		return nil, err
 	}
 	return f, err
@@ -136,7 +246,11 @@ func (w *WebDAV) Rename(old, new string) error {
 
 func (w *WebDAV) Delete(name string) error {
 	p := path.Join(w.p, name)
-	return w.c.RemoveAll(p)
+	err := w.c.RemoveAll(p)
+	if gowebdav.IsErrNotFound(err) {
+		return os.ErrNotExist
+	}
+	return err
 }
 
 func (w *WebDAV) Close() error {